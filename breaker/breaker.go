@@ -0,0 +1,216 @@
+package breaker
+
+import (
+	"context"
+	"fmt"
+	"github.com/QuRuijie/zenDB/prom"
+	"github.com/pkg/errors"
+	"sync"
+	"time"
+)
+
+type State int
+
+const (
+	StateClosed State = iota
+	StateHalfOpen
+	StateOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateHalfOpen:
+		return "half-open"
+	case StateOpen:
+		return "open"
+	}
+	return "unknown"
+}
+
+// ErrOpen 熔断器打开时返回的 *OpenError 满足 errors.Is(err, ErrOpen)
+var ErrOpen = errors.New("circuit breaker is open")
+
+// OpenError 熔断器打开时快速失败返回的错误
+type OpenError struct {
+	Name string
+	// RetryAfter 距离进入半开状态的剩余时间
+	RetryAfter time.Duration
+}
+
+func (e *OpenError) Error() string {
+	return fmt.Sprintf("circuit breaker %s is open, retry after %v", e.Name, e.RetryAfter)
+}
+
+func (e *OpenError) Is(target error) bool {
+	return target == ErrOpen
+}
+
+type Config struct {
+	// Window 统计错误率和慢请求比例的时间窗口
+	Window time.Duration
+	// MinRequests 窗口内请求数达到该值才会计算是否熔断
+	MinRequests int
+	// ErrorRate 窗口内失败比例达到该值时熔断, 0 表示不按错误率熔断
+	ErrorRate float64
+	// SlowThreshold 耗时超过该值算慢请求, 0 表示不按延迟熔断
+	SlowThreshold time.Duration
+	// SlowRate 窗口内慢请求比例达到该值时熔断
+	SlowRate float64
+	// CoolDown 打开后经过该时间进入半开状态
+	CoolDown time.Duration
+	// HalfOpenRequests 半开状态放行的探测请求数, 全部成功才关闭
+	HalfOpenRequests int
+	// IsFailure 判断 error 是否计为失败, 为空时除 context.Canceled 外的 error 都算失败
+	IsFailure func(err error) bool
+}
+
+// DefaultConfig 10s 窗口内至少 20 个请求且一半失败时熔断, 5s 后半开放行 1 个探测请求
+func DefaultConfig() Config {
+	return Config{
+		Window:           10 * time.Second,
+		MinRequests:      20,
+		ErrorRate:        0.5,
+		CoolDown:         5 * time.Second,
+		HalfOpenRequests: 1,
+	}
+}
+
+type Breaker struct {
+	client string
+	name   string
+	cfg    Config
+
+	mu          sync.Mutex
+	state       State
+	openedAt    time.Time
+	windowStart time.Time
+	total       int
+	failures    int
+	slows       int
+	probes      int
+	successes   int
+}
+
+// New 创建熔断器, client 和 name 作为 prom 指标 circuit_breaker_state 的标签
+func New(client, name string, cfg Config) *Breaker {
+	def := DefaultConfig()
+	if cfg.Window <= 0 {
+		cfg.Window = def.Window
+	}
+	if cfg.MinRequests <= 0 {
+		cfg.MinRequests = def.MinRequests
+	}
+	if cfg.CoolDown <= 0 {
+		cfg.CoolDown = def.CoolDown
+	}
+	if cfg.HalfOpenRequests <= 0 {
+		cfg.HalfOpenRequests = def.HalfOpenRequests
+	}
+	if cfg.IsFailure == nil {
+		cfg.IsFailure = func(err error) bool {
+			return err != nil && !errors.Is(err, context.Canceled)
+		}
+	}
+
+	b := &Breaker{client: client, name: name, cfg: cfg, windowStart: time.Now()}
+	prom.SetCircuitBreakerState(client, name, float64(StateClosed))
+	return b
+}
+
+func (b *Breaker) Name() string {
+	return b.name
+}
+
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Allow 请求前调用, 返回 nil 时请求结束后必须调用 Report
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if b.state == StateOpen {
+		if wait := b.cfg.CoolDown - now.Sub(b.openedAt); wait > 0 {
+			return &OpenError{Name: b.name, RetryAfter: wait}
+		}
+		b.setState(StateHalfOpen, now)
+	}
+
+	if b.state == StateHalfOpen {
+		if b.probes >= b.cfg.HalfOpenRequests {
+			return &OpenError{Name: b.name}
+		}
+		b.probes++
+	}
+	return nil
+}
+
+// Report 上报 Allow 放行的请求结果
+func (b *Breaker) Report(latency time.Duration, err error) {
+	failure := b.cfg.IsFailure(err)
+	slow := b.cfg.SlowThreshold > 0 && latency >= b.cfg.SlowThreshold
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	switch b.state {
+	case StateHalfOpen:
+		if failure || slow {
+			b.setState(StateOpen, now)
+			return
+		}
+		b.successes++
+		if b.successes >= b.cfg.HalfOpenRequests {
+			b.setState(StateClosed, now)
+		}
+	case StateClosed:
+		if now.Sub(b.windowStart) >= b.cfg.Window {
+			b.resetWindow(now)
+		}
+		b.total++
+		if failure {
+			b.failures++
+		}
+		if slow {
+			b.slows++
+		}
+		if b.shouldTrip() {
+			b.setState(StateOpen, now)
+		}
+	}
+}
+
+func (b *Breaker) shouldTrip() bool {
+	if b.total < b.cfg.MinRequests {
+		return false
+	}
+	total := float64(b.total)
+	if b.cfg.ErrorRate > 0 && float64(b.failures)/total >= b.cfg.ErrorRate {
+		return true
+	}
+	return b.cfg.SlowRate > 0 && float64(b.slows)/total >= b.cfg.SlowRate
+}
+
+func (b *Breaker) resetWindow(now time.Time) {
+	b.windowStart = now
+	b.total, b.failures, b.slows = 0, 0, 0
+}
+
+func (b *Breaker) setState(state State, now time.Time) {
+	b.state = state
+	b.probes, b.successes = 0, 0
+	switch state {
+	case StateOpen:
+		b.openedAt = now
+	case StateClosed:
+		b.resetWindow(now)
+	}
+	prom.SetCircuitBreakerState(b.client, b.name, float64(state))
+}
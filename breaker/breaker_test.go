@@ -0,0 +1,50 @@
+package breaker
+
+import (
+	"github.com/pkg/errors"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	defer func() {
+		t.Log("==================TestBreaker end====================")
+	}()
+	t.Log("==================TestBreaker begin==================")
+
+	b := New("test", "TestBreaker", Config{
+		Window:      time.Minute,
+		MinRequests: 4,
+		ErrorRate:   0.5,
+		CoolDown:    20 * time.Millisecond,
+	})
+	fail := errors.New("fail")
+
+	t.Log("[2 of 4 requests fail, breaker opens]")
+	for _, err := range []error{nil, fail, nil, fail} {
+		if e := b.Allow(); e != nil {
+			t.Fatal(e)
+		}
+		b.Report(time.Millisecond, err)
+	}
+	if b.State() != StateOpen {
+		t.Fatalf("breaker should be open, got %s", b.State())
+	}
+	if err := b.Allow(); !errors.Is(err, ErrOpen) {
+		t.Fatalf("open breaker should fail fast, got %v", err)
+	}
+
+	t.Log("[after cool down only one probe is allowed]")
+	time.Sleep(30 * time.Millisecond)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("half-open probe rejected: %v", err)
+	}
+	if err := b.Allow(); !errors.Is(err, ErrOpen) {
+		t.Fatalf("second half-open probe should be rejected, got %v", err)
+	}
+	b.Report(time.Millisecond, nil)
+	if b.State() != StateClosed {
+		t.Fatalf("breaker should be closed after probe success, got %s", b.State())
+	}
+	t.Log("TestBreaker Success")
+}
@@ -0,0 +1,166 @@
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrClosed 客户端开始关闭后新的操作返回该错误
+var ErrClosed = errors.New("client is closed")
+
+// UnfinishedError Shutdown 到期时还没有结束的操作和后台任务
+type UnfinishedError struct {
+	Name string
+	// Operations 操作名 -> 未结束的数量
+	Operations map[string]int
+	// Workers 未停止的后台任务数量, 如 change stream 订阅
+	Workers int
+}
+
+func (e *UnfinishedError) Error() string {
+	ops := make([]string, 0, len(e.Operations))
+	for op, n := range e.Operations {
+		ops = append(ops, fmt.Sprintf("%s=%d", op, n))
+	}
+	sort.Strings(ops)
+	return fmt.Sprintf("%s shutdown unfinished, operations: [%s], workers: %d", e.Name, strings.Join(ops, " "), e.Workers)
+}
+
+// Tracker 记录客户端进行中的操作和后台任务, 关闭时等待它们结束. nil Tracker 不做任何记录
+type Tracker struct {
+	name string
+
+	mu       sync.Mutex
+	closed   bool
+	inflight map[string]int
+	total    int
+	drained  chan struct{}
+	workers  map[int]func()
+	workerID int
+}
+
+func NewTracker(name string) *Tracker {
+	return &Tracker{
+		name:     name,
+		inflight: make(map[string]int),
+		drained:  make(chan struct{}),
+		workers:  make(map[int]func()),
+	}
+}
+
+// Begin 操作开始前调用, 返回 nil 时操作结束后必须调用 End
+func (t *Tracker) Begin(op string) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return ErrClosed
+	}
+	t.inflight[op]++
+	t.total++
+	return nil
+}
+
+func (t *Tracker) End(op string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.inflight[op]--; t.inflight[op] <= 0 {
+		delete(t.inflight, op)
+	}
+	if t.total--; t.total == 0 && t.closed {
+		close(t.drained)
+	}
+}
+
+// Register 登记后台任务, 关闭时调用 stop 停止它, 任务自己结束时调用返回的 unregister
+func (t *Tracker) Register(stop func()) (unregister func(), err error) {
+	if t == nil {
+		return func() {}, nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return nil, ErrClosed
+	}
+	t.workerID++
+	id := t.workerID
+	t.workers[id] = stop
+	return func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		delete(t.workers, id)
+	}, nil
+}
+
+func (t *Tracker) Closed() bool {
+	if t == nil {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.closed
+}
+
+// Shutdown 拒绝新的操作, 停止后台任务并等待进行中的操作结束,
+// ctx 到期时返回 *UnfinishedError, 重复调用时只等待不会重复停止
+func (t *Tracker) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	var stops []func()
+	if !t.closed {
+		t.closed = true
+		if t.total == 0 {
+			close(t.drained)
+		}
+		for _, stop := range t.workers {
+			stops = append(stops, stop)
+		}
+	}
+	t.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, stop := range stops {
+		wg.Add(1)
+		go func(stop func()) {
+			defer wg.Done()
+			stop()
+		}(stop)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+	}
+	select {
+	case <-t.drained:
+	case <-ctx.Done():
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.total == 0 && len(t.workers) == 0 {
+		return nil
+	}
+	ops := make(map[string]int, len(t.inflight))
+	for op, n := range t.inflight {
+		ops[op] = n
+	}
+	return &UnfinishedError{Name: t.name, Operations: ops, Workers: len(t.workers)}
+}
@@ -0,0 +1,53 @@
+package lifecycle
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"testing"
+	"time"
+)
+
+func TestTracker(t *testing.T) {
+	defer func() {
+		t.Log("==================TestTracker end====================")
+	}()
+	t.Log("==================TestTracker begin==================")
+
+	tracker := NewTracker("test")
+	if err := tracker.Begin("FindOne"); err != nil {
+		t.Fatal(err)
+	}
+	stopped := make(chan struct{})
+	unregister, err := tracker.Register(func() { close(stopped) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Log("[shutdown stops workers and waits for in-flight operations]")
+	go func() {
+		<-stopped
+		unregister()
+		time.Sleep(10 * time.Millisecond)
+		tracker.End("FindOne")
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := tracker.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown should drain, got %v", err)
+	}
+	if err := tracker.Begin("FindOne"); !errors.Is(err, ErrClosed) {
+		t.Fatalf("closed tracker should reject operations, got %v", err)
+	}
+
+	t.Log("[shutdown reports operations left after the deadline]")
+	tracker = NewTracker("test")
+	_ = tracker.Begin("UpdateOne")
+	_ = tracker.Begin("UpdateOne")
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	var unfinished *UnfinishedError
+	if err := tracker.Shutdown(ctx); !errors.As(err, &unfinished) || unfinished.Operations["UpdateOne"] != 2 {
+		t.Fatalf("shutdown should report unfinished operations, got %v", err)
+	}
+	t.Log("TestTracker Success")
+}
@@ -1,6 +1,8 @@
 package prom
 
 import (
+	"context"
+	"errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -10,6 +12,16 @@ import (
 	"time"
 )
 
+const (
+	StatusSuccess = "Success"
+	StatusFail    = "Fail"
+	StatusCancel  = "Cancel"
+	StatusTimeout = "Timeout"
+
+	TransactionCommit = "Commit"
+	TransactionAbort  = "Abort"
+)
+
 var (
 	//------------------------mongo metrics------------------------
 	mongoRequestDuration = promauto.NewSummaryVec(prometheus.SummaryOpts{
@@ -22,6 +34,31 @@ var (
 		Help: "The count of processed mongo requests",
 	}, []string{"method", "status"})
 
+	mongoTransactionCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mongo_transaction_count",
+		Help: "The count of committed and aborted mongo transactions",
+	}, []string{"result"})
+
+	mongoRetryCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mongo_retry_count",
+		Help: "The count of retried mongo requests",
+	}, []string{"method"})
+
+	mongoChangeStreamEventCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mongo_change_stream_event_count",
+		Help: "The count of processed mongo change stream events",
+	}, []string{"db", "coll"})
+
+	mongoChangeStreamLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mongo_change_stream_lag_seconds",
+		Help: "The seconds between a change event's cluster time and its processing",
+	}, []string{"db", "coll"})
+
+	mongoChangeStreamErrorCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mongo_change_stream_error_count",
+		Help: "The count of mongo change stream disconnects and reopen failures",
+	}, []string{"db", "coll"})
+
 	//------------------------redis  metrics------------------------
 	redisRequestDuration = promauto.NewSummaryVec(prometheus.SummaryOpts{
 		Name: "redis_request_duration",
@@ -32,6 +69,43 @@ var (
 		Name: "redis_request_count",
 		Help: "The count of processed redis requests",
 	}, []string{"method", "status"})
+
+	redisScriptDuration = promauto.NewSummaryVec(prometheus.SummaryOpts{
+		Name: "redis_script_duration",
+		Help: "The duration of processed redis lua scripts",
+	}, []string{"script", "status"})
+
+	redisScriptCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "redis_script_count",
+		Help: "The count of processed redis lua scripts",
+	}, []string{"script", "status"})
+
+	rateLimitCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rate_limit_count",
+		Help: "The count of allowed and limited rate limiter requests",
+	}, []string{"limiter", "result"})
+
+	//------------------------queue metrics------------------------
+	queueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "queue_depth",
+		Help: "The count of pending, processing and dead jobs in redis queues",
+	}, []string{"queue", "state"})
+
+	queueOldestAge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "queue_oldest_age_seconds",
+		Help: "The seconds since the oldest pending job was enqueued",
+	}, []string{"queue"})
+
+	queueJobCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "queue_job_count",
+		Help: "The count of acked, retried and dead-lettered queue jobs",
+	}, []string{"queue", "result"})
+
+	//------------------------breaker metrics------------------------
+	circuitBreakerState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "circuit_breaker_state",
+		Help: "The state of client circuit breakers, 0 closed, 1 half-open, 2 open",
+	}, []string{"client", "name"})
 )
 
 // StartServer 开启服务器, 等待prometheus拉取指标
@@ -50,12 +124,76 @@ func SetMongoMetrics(duration float64, method ,status string) {
 	mongoRequestCount.WithLabelValues(method, status).Add(1)
 }
 
+// SetMongoTransactionMetrics 设置mongo事务提交/回滚指标
+func SetMongoTransactionMetrics(result string) {
+	mongoTransactionCount.WithLabelValues(result).Add(1)
+}
+
+// SetMongoRetryMetrics 设置mongo重试次数指标
+func SetMongoRetryMetrics(method string) {
+	mongoRetryCount.WithLabelValues(method).Add(1)
+}
+
+// SetMongoChangeStreamMetrics 设置change stream吞吐量和延迟指标
+func SetMongoChangeStreamMetrics(db, coll string, lag float64) {
+	mongoChangeStreamEventCount.WithLabelValues(db, coll).Add(1)
+	mongoChangeStreamLag.WithLabelValues(db, coll).Set(lag)
+}
+
+// SetMongoChangeStreamErrorMetrics 设置change stream断线指标
+func SetMongoChangeStreamErrorMetrics(db, coll string) {
+	mongoChangeStreamErrorCount.WithLabelValues(db, coll).Add(1)
+}
+
 // SetRedisMetrics 设置redis指标
 func SetRedisMetrics(duration float64, method ,status string) {
 	redisRequestDuration.WithLabelValues(method, status).Observe(duration)
 	redisRequestCount.WithLabelValues(method, status).Add(1)
 }
 
+// SetRedisScriptMetrics 设置redis lua脚本指标
+func SetRedisScriptMetrics(duration float64, script, status string) {
+	redisScriptDuration.WithLabelValues(script, status).Observe(duration)
+	redisScriptCount.WithLabelValues(script, status).Add(1)
+}
+
+// SetRateLimitMetrics 设置限流器放行/限流次数指标
+func SetRateLimitMetrics(limiter, result string) {
+	rateLimitCount.WithLabelValues(limiter, result).Add(1)
+}
+
+// SetQueueDepthMetrics 设置队列长度和最早任务等待时间指标
+func SetQueueDepthMetrics(queue string, pending, processing, dead int64, oldestAge float64) {
+	queueDepth.WithLabelValues(queue, "pending").Set(float64(pending))
+	queueDepth.WithLabelValues(queue, "processing").Set(float64(processing))
+	queueDepth.WithLabelValues(queue, "dead").Set(float64(dead))
+	queueOldestAge.WithLabelValues(queue).Set(oldestAge)
+}
+
+// SetQueueJobMetrics 设置队列任务完成/重试/进入死信指标
+func SetQueueJobMetrics(queue, result string) {
+	queueJobCount.WithLabelValues(queue, result).Add(1)
+}
+
+// Status 根据请求结果返回指标的status标签, 被取消或超时的请求与普通失败区分开
+func Status(ctx context.Context, err error) string {
+	if err == nil {
+		return StatusSuccess
+	}
+	if errors.Is(err, context.Canceled) || (ctx != nil && errors.Is(ctx.Err(), context.Canceled)) {
+		return StatusCancel
+	}
+	if errors.Is(err, context.DeadlineExceeded) || (ctx != nil && errors.Is(ctx.Err(), context.DeadlineExceeded)) {
+		return StatusTimeout
+	}
+	return StatusFail
+}
+
+// SetCircuitBreakerState 设置熔断器状态指标
+func SetCircuitBreakerState(client, name string, state float64) {
+	circuitBreakerState.WithLabelValues(client, name).Set(state)
+}
+
 func NowMicrosecond() (now int64) {
 	return time.Now().UnixMicro()
 }
@@ -0,0 +1,46 @@
+package zmgo
+
+import (
+	"context"
+	"github.com/QuRuijie/zenDB/breaker"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"time"
+)
+
+// SetCircuitBreaker 为客户端开启熔断器, name 一般是项目名, 作为 prom 指标的标签,
+// 熔断时所有操作直接返回 *breaker.OpenError, 需要在客户端开始使用前设置
+func (c *MongoClient) SetCircuitBreaker(name string, cfg breaker.Config) {
+	if cfg.IsFailure == nil {
+		cfg.IsFailure = isMongoFailure
+	}
+	c.breaker = breaker.New("mongo", name, cfg)
+}
+
+// CircuitBreaker 返回客户端的熔断器, 没有开启时返回 nil
+func (c *MongoClient) CircuitBreaker() *breaker.Breaker {
+	return c.breaker
+}
+
+func (c *MongoClient) withBreaker(fn func() error) error {
+	b := c.breaker
+	if b == nil {
+		return fn()
+	}
+	if err := b.Allow(); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	err := fn()
+	b.Report(time.Since(start), err)
+	return err
+}
+
+// isMongoFailure 查询不到文档和唯一键冲突是业务结果, 不代表数据库异常
+func isMongoFailure(err error) bool {
+	return err != nil &&
+		!errors.Is(err, context.Canceled) &&
+		!errors.Is(err, mongo.ErrNoDocuments) &&
+		!mongo.IsDuplicateKeyError(err)
+}
@@ -0,0 +1,44 @@
+// Package builder 构造 zmgo 使用的 filter, update, sort 和 projection, 在发送前检查操作符的用法.
+//
+// 所有 builder 都实现了 bson.Marshaler, 可以直接作为参数传给 zmgo 的方法:
+//
+//	zmgo.FindAll(&users, db, coll, builder.Eq("name", "pig").Range("age", 18, 30))
+//	zmgo.UpdateOne(db, coll, builder.Eq("_id", 1), builder.Set("name", "bigPig").Inc("level", 1))
+//
+// 用法错误会一直保留到 Build 或 MarshalBSON 时返回, 请求不会发送到服务器.
+package builder
+
+import (
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"strings"
+)
+
+// ErrInvalid 所有 builder 用法错误都满足 errors.Is(err, ErrInvalid)
+var ErrInvalid = errors.New("invalid builder usage")
+
+func invalid(format string, args ...interface{}) error {
+	return errors.Wrapf(ErrInvalid, format, args...)
+}
+
+func checkField(field string) error {
+	if field == "" {
+		return invalid("empty field name")
+	}
+	if strings.HasPrefix(field, "$") {
+		return invalid("field %q must not start with $", field)
+	}
+	return nil
+}
+
+// fieldsConflict a 和 b 相同或者一个是另一个的父路径, 如 "a" 和 "a.b"
+func fieldsConflict(a, b string) bool {
+	return a == b || strings.HasPrefix(a, b+".") || strings.HasPrefix(b, a+".")
+}
+
+func marshal(d bson.D, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	return bson.Marshal(d)
+}
@@ -0,0 +1,70 @@
+package builder
+
+import (
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"reflect"
+	"testing"
+)
+
+func TestBuilder(t *testing.T) {
+	defer func() {
+		t.Log("==================TestBuilder end====================")
+	}()
+	t.Log("==================TestBuilder begin==================")
+
+	t.Log("[conditions on the same field are merged]")
+	filter := Eq("name", "pig").Range("age", 18, 30).In("tag", []string{"a", "b"}).
+		Or(Exists("vip", true), Gt("level", 10))
+	got, err := filter.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := bson.D{
+		{Key: "name", Value: "pig"},
+		{Key: "age", Value: bson.D{{Key: "$gte", Value: 18}, {Key: "$lt", Value: 30}}},
+		{Key: "tag", Value: bson.D{{Key: "$in", Value: []string{"a", "b"}}}},
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "vip", Value: bson.D{{Key: "$exists", Value: true}}}},
+			bson.D{{Key: "level", Value: bson.D{{Key: "$gt", Value: 10}}}},
+		}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("filter Fail:\n got: %v\nwant: %v", got, want)
+	}
+
+	t.Log("[update groups fields by operator and marshals]")
+	update := Set("name", "bigPig").Inc("level", 1).Set("updatedAt", 1).Push("logs", "a", "b")
+	if _, err = bson.Marshal(update); err != nil {
+		t.Fatal(err)
+	}
+	got, _ = update.Build()
+	if len(got) != 3 || len(got[0].Value.(bson.D)) != 2 {
+		t.Fatalf("update Fail: %v", got)
+	}
+
+	t.Log("[invalid usage is reported before sending]")
+	cases := []struct {
+		name string
+		err  error
+	}{
+		{"duplicate operator", Gt("age", 1).Gt("age", 2).Err()},
+		{"eq with other condition", Eq("age", 1).Lt("age", 2).Err()},
+		{"empty or", Or().Err()},
+		{"dollar field", Eq("$where", "1").Err()},
+		{"invalid nested filter", And(Eq("a", 1), Gt("b", 1).Gt("b", 2)).Err()},
+		{"inc non number", Inc("level", "1").Err()},
+		{"update path conflict", Set("a", 1).Unset("a.b").Err()},
+		{"mixed projection", Include("name").Exclude("age").Err()},
+		{"duplicate sort", Asc("name").Desc("name").Err()},
+	}
+	for _, c := range cases {
+		if !errors.Is(c.err, ErrInvalid) {
+			t.Fatalf("%s should be invalid, got %v", c.name, c.err)
+		}
+	}
+	if _, err = bson.Marshal(NewUpdate()); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("empty update should fail to marshal, got %v", err)
+	}
+	t.Log("TestBuilder Success")
+}
@@ -0,0 +1,229 @@
+package builder
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"reflect"
+)
+
+// Filter 查询条件, 同一字段上的多个操作符会合并, 如 Gte("age", 18).Lt("age", 30) -> {age: {$gte: 18, $lt: 30}}
+type Filter struct {
+	d bson.D
+	// eqs 用 Eq 指定了值的字段, 不能再加其他条件
+	eqs map[string]bool
+	err error
+}
+
+func NewFilter() *Filter {
+	return &Filter{d: bson.D{}, eqs: map[string]bool{}}
+}
+
+func Eq(field string, value interface{}) *Filter       { return NewFilter().Eq(field, value) }
+func Ne(field string, value interface{}) *Filter       { return NewFilter().Ne(field, value) }
+func Gt(field string, value interface{}) *Filter       { return NewFilter().Gt(field, value) }
+func Gte(field string, value interface{}) *Filter      { return NewFilter().Gte(field, value) }
+func Lt(field string, value interface{}) *Filter       { return NewFilter().Lt(field, value) }
+func Lte(field string, value interface{}) *Filter      { return NewFilter().Lte(field, value) }
+func In(field string, values ...interface{}) *Filter   { return NewFilter().In(field, values...) }
+func Nin(field string, values ...interface{}) *Filter  { return NewFilter().Nin(field, values...) }
+func Range(field string, min, max interface{}) *Filter { return NewFilter().Range(field, min, max) }
+func Exists(field string, exists bool) *Filter         { return NewFilter().Exists(field, exists) }
+func Regex(field, pattern, options string) *Filter     { return NewFilter().Regex(field, pattern, options) }
+func ElemMatch(field string, cond *Filter) *Filter     { return NewFilter().ElemMatch(field, cond) }
+func And(filters ...*Filter) *Filter                   { return NewFilter().And(filters...) }
+func Or(filters ...*Filter) *Filter                    { return NewFilter().Or(filters...) }
+func Nor(filters ...*Filter) *Filter                   { return NewFilter().Nor(filters...) }
+
+func (f *Filter) Eq(field string, value interface{}) *Filter {
+	if f.err != nil {
+		return f
+	}
+	if f.err = checkField(field); f.err != nil {
+		return f
+	}
+	if f.index(field) >= 0 {
+		f.err = invalid("field %q already has a condition", field)
+		return f
+	}
+	f.eqs[field] = true
+	f.d = append(f.d, bson.E{Key: field, Value: value})
+	return f
+}
+
+func (f *Filter) Ne(field string, value interface{}) *Filter {
+	return f.cond(field, "$ne", value)
+}
+
+func (f *Filter) Gt(field string, value interface{}) *Filter {
+	return f.cond(field, "$gt", value)
+}
+
+func (f *Filter) Gte(field string, value interface{}) *Filter {
+	return f.cond(field, "$gte", value)
+}
+
+func (f *Filter) Lt(field string, value interface{}) *Filter {
+	return f.cond(field, "$lt", value)
+}
+
+func (f *Filter) Lte(field string, value interface{}) *Filter {
+	return f.cond(field, "$lte", value)
+}
+
+// In 可以传多个值, 也可以只传一个 slice
+func (f *Filter) In(field string, values ...interface{}) *Filter {
+	return f.cond(field, "$in", flatten(values))
+}
+
+func (f *Filter) Nin(field string, values ...interface{}) *Filter {
+	return f.cond(field, "$nin", flatten(values))
+}
+
+// Range min <= field < max, 为 nil 的一端不限制
+func (f *Filter) Range(field string, min, max interface{}) *Filter {
+	if min == nil && max == nil {
+		if f.err == nil {
+			f.err = invalid("range of %q needs at least one bound", field)
+		}
+		return f
+	}
+	if min != nil {
+		f.cond(field, "$gte", min)
+	}
+	if max != nil {
+		f.cond(field, "$lt", max)
+	}
+	return f
+}
+
+func (f *Filter) Exists(field string, exists bool) *Filter {
+	return f.cond(field, "$exists", exists)
+}
+
+func (f *Filter) Regex(field, pattern, options string) *Filter {
+	return f.cond(field, "$regex", primitive.Regex{Pattern: pattern, Options: options})
+}
+
+// ElemMatch 数组中至少有一个元素满足 cond, 数组元素需要是文档
+func (f *Filter) ElemMatch(field string, cond *Filter) *Filter {
+	d, err := cond.Build()
+	if err != nil {
+		if f.err == nil {
+			f.err = err
+		}
+		return f
+	}
+	if len(d) == 0 && f.err == nil {
+		f.err = invalid("empty $elemMatch of %q", field)
+		return f
+	}
+	return f.cond(field, "$elemMatch", d)
+}
+
+func (f *Filter) And(filters ...*Filter) *Filter {
+	return f.logical("$and", filters)
+}
+
+func (f *Filter) Or(filters ...*Filter) *Filter {
+	return f.logical("$or", filters)
+}
+
+func (f *Filter) Nor(filters ...*Filter) *Filter {
+	return f.logical("$nor", filters)
+}
+
+func (f *Filter) Err() error {
+	return f.err
+}
+
+// Build 返回构造好的 bson.D, 没有条件时返回空文档匹配所有
+func (f *Filter) Build() (bson.D, error) {
+	if f == nil {
+		return nil, invalid("nil filter")
+	}
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.d, nil
+}
+
+func (f *Filter) MarshalBSON() ([]byte, error) {
+	return marshal(f.Build())
+}
+
+func (f *Filter) index(key string) int {
+	for i, e := range f.d {
+		if e.Key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func (f *Filter) cond(field, op string, value interface{}) *Filter {
+	if f.err != nil {
+		return f
+	}
+	if f.err = checkField(field); f.err != nil {
+		return f
+	}
+	if f.eqs[field] {
+		f.err = invalid("field %q already has an equality condition", field)
+		return f
+	}
+
+	i := f.index(field)
+	if i < 0 {
+		f.d = append(f.d, bson.E{Key: field, Value: bson.D{{Key: op, Value: value}}})
+		return f
+	}
+	ops := f.d[i].Value.(bson.D)
+	for _, e := range ops {
+		if e.Key == op {
+			f.err = invalid("duplicate %s on field %q", op, field)
+			return f
+		}
+	}
+	f.d[i].Value = append(ops, bson.E{Key: op, Value: value})
+	return f
+}
+
+func (f *Filter) logical(op string, filters []*Filter) *Filter {
+	if f.err != nil {
+		return f
+	}
+	if len(filters) == 0 {
+		f.err = invalid("%s needs at least one filter", op)
+		return f
+	}
+	if f.index(op) >= 0 {
+		f.err = invalid("duplicate %s, nest them with And", op)
+		return f
+	}
+
+	conds := make(bson.A, 0, len(filters))
+	for _, sub := range filters {
+		d, err := sub.Build()
+		if err != nil {
+			f.err = err
+			return f
+		}
+		conds = append(conds, d)
+	}
+	f.d = append(f.d, bson.E{Key: op, Value: conds})
+	return f
+}
+
+// flatten In("a", []int{1, 2}) 和 In("a", 1, 2) 等价
+func flatten(values []interface{}) interface{} {
+	if len(values) == 1 && values[0] != nil {
+		v := reflect.ValueOf(values[0])
+		if (v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8) || v.Kind() == reflect.Array {
+			return values[0]
+		}
+	}
+	if values == nil {
+		return bson.A{}
+	}
+	return bson.A(values)
+}
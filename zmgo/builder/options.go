@@ -0,0 +1,143 @@
+package builder
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Sort 排序, 按调用顺序决定优先级, 用于 options.Find().SetSort 等
+type Sort struct {
+	d   bson.D
+	err error
+}
+
+func NewSort() *Sort {
+	return &Sort{d: bson.D{}}
+}
+
+func Asc(fields ...string) *Sort  { return NewSort().Asc(fields...) }
+func Desc(fields ...string) *Sort { return NewSort().Desc(fields...) }
+
+func (s *Sort) Asc(fields ...string) *Sort {
+	return s.add(fields, 1)
+}
+
+func (s *Sort) Desc(fields ...string) *Sort {
+	return s.add(fields, -1)
+}
+
+func (s *Sort) Err() error {
+	return s.err
+}
+
+func (s *Sort) Build() (bson.D, error) {
+	if s == nil {
+		return nil, invalid("nil sort")
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.d, nil
+}
+
+func (s *Sort) MarshalBSON() ([]byte, error) {
+	return marshal(s.Build())
+}
+
+func (s *Sort) add(fields []string, order int) *Sort {
+	for _, field := range fields {
+		if s.err != nil {
+			return s
+		}
+		if s.err = checkField(field); s.err != nil {
+			return s
+		}
+		for _, e := range s.d {
+			if e.Key == field {
+				s.err = invalid("duplicate sort field %q", field)
+				return s
+			}
+		}
+		s.d = append(s.d, bson.E{Key: field, Value: order})
+	}
+	return s
+}
+
+// Projection 返回字段, 除 _id 外不能同时包含和排除字段
+type Projection struct {
+	d bson.D
+	// mode 1 为包含, 0 为排除, -1 为还未确定
+	mode int
+	err  error
+}
+
+func NewProjection() *Projection {
+	return &Projection{d: bson.D{}, mode: -1}
+}
+
+func Include(fields ...string) *Projection { return NewProjection().Include(fields...) }
+func Exclude(fields ...string) *Projection { return NewProjection().Exclude(fields...) }
+
+func (p *Projection) Include(fields ...string) *Projection {
+	return p.add(fields, 1)
+}
+
+func (p *Projection) Exclude(fields ...string) *Projection {
+	return p.add(fields, 0)
+}
+
+// Slice 数组字段只返回前 n 个元素, n 为负数时返回后 |n| 个
+func (p *Projection) Slice(field string, n int) *Projection {
+	return p.set(field, bson.D{{Key: "$slice", Value: n}})
+}
+
+func (p *Projection) Err() error {
+	return p.err
+}
+
+func (p *Projection) Build() (bson.D, error) {
+	if p == nil {
+		return nil, invalid("nil projection")
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	return p.d, nil
+}
+
+func (p *Projection) MarshalBSON() ([]byte, error) {
+	return marshal(p.Build())
+}
+
+func (p *Projection) add(fields []string, mode int) *Projection {
+	for _, field := range fields {
+		if p.err != nil {
+			return p
+		}
+		if field != "_id" {
+			if p.mode >= 0 && p.mode != mode {
+				p.err = invalid("projection cannot mix inclusion and exclusion, field %q", field)
+				return p
+			}
+			p.mode = mode
+		}
+		p.set(field, mode)
+	}
+	return p
+}
+
+func (p *Projection) set(field string, value interface{}) *Projection {
+	if p.err != nil {
+		return p
+	}
+	if p.err = checkField(field); p.err != nil {
+		return p
+	}
+	for _, e := range p.d {
+		if fieldsConflict(e.Key, field) {
+			p.err = invalid("projection field %q conflicts with %q", field, e.Key)
+			return p
+		}
+	}
+	p.d = append(p.d, bson.E{Key: field, Value: value})
+	return p
+}
@@ -0,0 +1,144 @@
+package builder
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"reflect"
+)
+
+// Update 更新操作, 同一字段(包括父子路径)只能出现在一个操作里, 否则服务器会返回冲突错误
+type Update struct {
+	d bson.D
+	// fields 字段 -> 所在的操作符
+	fields map[string]string
+	err    error
+}
+
+func NewUpdate() *Update {
+	return &Update{d: bson.D{}, fields: map[string]string{}}
+}
+
+func Set(field string, value interface{}) *Update { return NewUpdate().Set(field, value) }
+func SetOnInsert(field string, value interface{}) *Update {
+	return NewUpdate().SetOnInsert(field, value)
+}
+func Inc(field string, value interface{}) *Update      { return NewUpdate().Inc(field, value) }
+func Push(field string, values ...interface{}) *Update { return NewUpdate().Push(field, values...) }
+func AddToSet(field string, values ...interface{}) *Update {
+	return NewUpdate().AddToSet(field, values...)
+}
+func Unset(fields ...string) *Update { return NewUpdate().Unset(fields...) }
+
+func (u *Update) Set(field string, value interface{}) *Update {
+	return u.add("$set", field, value)
+}
+
+// SetOnInsert 只在 upsert 插入新文档时生效
+func (u *Update) SetOnInsert(field string, value interface{}) *Update {
+	return u.add("$setOnInsert", field, value)
+}
+
+// Inc value 必须是数字
+func (u *Update) Inc(field string, value interface{}) *Update {
+	if u.err == nil && !isNumber(value) {
+		u.err = invalid("$inc of %q needs a number, got %T", field, value)
+		return u
+	}
+	return u.add("$inc", field, value)
+}
+
+// Push 多个值时使用 $each 逐个追加
+func (u *Update) Push(field string, values ...interface{}) *Update {
+	return u.addValues("$push", field, values)
+}
+
+// AddToSet 多个值时使用 $each 逐个加入
+func (u *Update) AddToSet(field string, values ...interface{}) *Update {
+	return u.addValues("$addToSet", field, values)
+}
+
+func (u *Update) Unset(fields ...string) *Update {
+	if len(fields) == 0 && u.err == nil {
+		u.err = invalid("$unset needs at least one field")
+	}
+	for _, field := range fields {
+		u.add("$unset", field, "")
+	}
+	return u
+}
+
+func (u *Update) Err() error {
+	return u.err
+}
+
+// Build 返回构造好的 bson.D, 没有任何操作时返回错误
+func (u *Update) Build() (bson.D, error) {
+	if u == nil {
+		return nil, invalid("nil update")
+	}
+	if u.err != nil {
+		return nil, u.err
+	}
+	if len(u.d) == 0 {
+		return nil, invalid("empty update")
+	}
+	return u.d, nil
+}
+
+func (u *Update) MarshalBSON() ([]byte, error) {
+	return marshal(u.Build())
+}
+
+func (u *Update) add(op, field string, value interface{}) *Update {
+	if u.err != nil {
+		return u
+	}
+	if u.err = checkField(field); u.err != nil {
+		return u
+	}
+	for f, o := range u.fields {
+		if fieldsConflict(f, field) {
+			u.err = invalid("%s %q conflicts with %s %q", op, field, o, f)
+			return u
+		}
+	}
+	u.fields[field] = op
+
+	for i, e := range u.d {
+		if e.Key == op {
+			u.d[i].Value = append(e.Value.(bson.D), bson.E{Key: field, Value: value})
+			return u
+		}
+	}
+	u.d = append(u.d, bson.E{Key: op, Value: bson.D{{Key: field, Value: value}}})
+	return u
+}
+
+func (u *Update) addValues(op, field string, values []interface{}) *Update {
+	switch len(values) {
+	case 0:
+		if u.err == nil {
+			u.err = invalid("%s of %q needs at least one value", op, field)
+		}
+		return u
+	case 1:
+		return u.add(op, field, values[0])
+	}
+	return u.add(op, field, bson.D{{Key: "$each", Value: bson.A(values)}})
+}
+
+func isNumber(value interface{}) bool {
+	if _, ok := value.(primitive.Decimal128); ok {
+		return true
+	}
+	if value == nil {
+		return false
+	}
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,33 @@
+package zmgo
+
+import (
+	"context"
+)
+
+// --------------------------------- Method without Client ---------------------------------------
+
+// Close 关闭 Init 创建的所有客户端和 Clients().WatchFile, 关闭后需要重新 Init 才能使用
+func Close(ctx context.Context) error {
+	registryMu.Lock()
+	if !IsInit {
+		registryMu.Unlock()
+		return nil
+	}
+	IsInit = false
+	r := registry
+	registry = newGlobalRegistry(false)
+	registryMu.Unlock()
+	return r.Close(ctx)
+}
+
+// --------------------------------- Method with Client --------------------------------------------
+
+// Close 拒绝新的操作(返回 lifecycle.ErrClosed), 停止 Watch 订阅, 等待进行中的操作和事务结束后断开连接.
+// ctx 到期时强制断开连接, 并返回 *lifecycle.UnfinishedError 说明哪些操作没有完成
+func (c *MongoClient) Close(ctx context.Context) error {
+	unfinished := c.life.Shutdown(ctx)
+	if err := c.client.Disconnect(ctx); err != nil && unfinished == nil {
+		return err
+	}
+	return unfinished
+}
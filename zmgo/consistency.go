@@ -0,0 +1,154 @@
+package zmgo
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+	"time"
+)
+
+// ReadWriteOptions 覆盖读偏好, 读关注和写关注, 为空的字段沿用上一级的设置
+// 优先级: ctx(WithReadWriteOptions) > 集合(SetCollectionOptions) > 库(SetDatabaseOptions) > 客户端
+type ReadWriteOptions struct {
+	ReadPreference *readpref.ReadPref
+	// MaxStaleness 从节点最大延迟, 只对非 primary 的读偏好生效, 不能小于 90s
+	MaxStaleness time.Duration
+	ReadConcern  *readconcern.ReadConcern
+	WriteConcern *writeconcern.WriteConcern
+}
+
+func (o *ReadWriteOptions) readPref() *readpref.ReadPref {
+	rp := o.ReadPreference
+	if rp == nil || o.MaxStaleness <= 0 || rp.Mode() == readpref.PrimaryMode {
+		return rp
+	}
+	rp, err := readpref.New(rp.Mode(), readpref.WithMaxStaleness(o.MaxStaleness), readpref.WithTagSets(rp.TagSets()...))
+	if err != nil {
+		return o.ReadPreference
+	}
+	return rp
+}
+
+func (o *ReadWriteOptions) database() *options.DatabaseOptions {
+	return options.Database().SetReadPreference(o.readPref()).SetReadConcern(o.ReadConcern).SetWriteConcern(o.WriteConcern)
+}
+
+func (o *ReadWriteOptions) collection() *options.CollectionOptions {
+	return options.Collection().SetReadPreference(o.readPref()).SetReadConcern(o.ReadConcern).SetWriteConcern(o.WriteConcern)
+}
+
+type readWriteOptionsKey struct{}
+
+// WithReadWriteOptions 只对使用该 ctx 的操作生效, 事务内的操作忽略该设置
+func WithReadWriteOptions(ctx context.Context, opts ReadWriteOptions) context.Context {
+	return context.WithValue(ctx, readWriteOptionsKey{}, &opts)
+}
+
+// WithPrimary 该 ctx 上的读操作读 primary
+func WithPrimary(ctx context.Context) context.Context {
+	return WithReadWriteOptions(ctx, ReadWriteOptions{ReadPreference: readpref.Primary()})
+}
+
+// SetDatabaseOptions 设置 dbName 库所有集合的读写选项, 需要在客户端开始使用前设置
+func (c *MongoClient) SetDatabaseOptions(dbName string, opts ReadWriteOptions) {
+	c.optsMu.Lock()
+	defer c.optsMu.Unlock()
+	if c.dbOpts == nil {
+		c.dbOpts = make(map[string]*ReadWriteOptions)
+	}
+	c.dbOpts[dbName] = &opts
+
+	c.dbsMu.Lock()
+	delete(c.dbs, dbName)
+	c.dbsMu.Unlock()
+}
+
+// SetCollectionOptions 设置 dbName.collName 集合的读写选项, 需要在客户端开始使用前设置
+func (c *MongoClient) SetCollectionOptions(dbName, collName string, opts ReadWriteOptions) {
+	c.optsMu.Lock()
+	defer c.optsMu.Unlock()
+	if c.collOpts == nil {
+		c.collOpts = make(map[string]*ReadWriteOptions)
+	}
+	c.collOpts[dbName+"."+collName] = &opts
+}
+
+// SetReadYourWrites 集合写入后的 window 时间内, 该集合上的读操作都读 primary, 避免读到从节点上的旧数据, 0 表示关闭
+func (c *MongoClient) SetReadYourWrites(window time.Duration) {
+	c.optsMu.Lock()
+	defer c.optsMu.Unlock()
+	c.rywWindow = window
+}
+
+func (c *MongoClient) databaseOptions(dbName string) []*options.DatabaseOptions {
+	c.optsMu.RLock()
+	defer c.optsMu.RUnlock()
+	if o, ok := c.dbOpts[dbName]; ok {
+		return []*options.DatabaseOptions{o.database()}
+	}
+	return nil
+}
+
+func (c *MongoClient) collectionOptions(dbName, collName string) []*options.CollectionOptions {
+	c.optsMu.RLock()
+	defer c.optsMu.RUnlock()
+	if o, ok := c.collOpts[dbName+"."+collName]; ok {
+		return []*options.CollectionOptions{o.collection()}
+	}
+	return nil
+}
+
+// collection 返回应用了 ctx 上的读写选项和 read-your-writes 的集合
+func (c *MongoClient) collection(ctx context.Context, dbName, collName string) *mongo.Collection {
+	coll := c.DbColl(dbName, collName)
+	if inTransaction(ctx) {
+		return coll
+	}
+
+	opts := c.operationOptions(ctx, dbName+"."+collName)
+	if opts == nil {
+		return coll
+	}
+	clone, err := coll.Clone(opts)
+	if err != nil {
+		return coll
+	}
+	return clone
+}
+
+// operationOptions 单次操作需要覆盖的选项, 不需要覆盖时返回 nil
+func (c *MongoClient) operationOptions(ctx context.Context, key string) *options.CollectionOptions {
+	c.optsMu.RLock()
+	window := c.rywWindow
+	c.optsMu.RUnlock()
+
+	var opts []*options.CollectionOptions
+	if window > 0 {
+		if last, ok := c.lastWrites.Load(key); ok && time.Since(last.(time.Time)) < window {
+			opts = append(opts, options.Collection().SetReadPreference(readpref.Primary()))
+		}
+	}
+	if o, ok := ctx.Value(readWriteOptionsKey{}).(*ReadWriteOptions); ok {
+		opts = append(opts, o.collection())
+	}
+	if len(opts) == 0 {
+		return nil
+	}
+	return options.MergeCollectionOptions(opts...)
+}
+
+// recordWrite 写入成功后记录写入时间, 失败的写入和事务内的写入不记录
+func (c *MongoClient) recordWrite(ctx context.Context, dbName, collName string, err error) {
+	if err != nil || inTransaction(ctx) {
+		return
+	}
+	c.optsMu.RLock()
+	window := c.rywWindow
+	c.optsMu.RUnlock()
+	if window > 0 {
+		c.lastWrites.Store(dbName+"."+collName, time.Now())
+	}
+}
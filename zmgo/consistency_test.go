@@ -0,0 +1,65 @@
+package zmgo
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"testing"
+	"time"
+)
+
+func TestReadWriteOptions(t *testing.T) {
+	defer func() {
+		t.Log("==================TestReadWriteOptions end====================")
+	}()
+	t.Log("==================TestReadWriteOptions begin==================")
+
+	c := &MongoClient{}
+	ctx := context.Background()
+	key := DB_NAME + "." + COL_NAME
+
+	t.Log("[database and collection options]")
+	c.SetDatabaseOptions(DB_NAME, ReadWriteOptions{ReadConcern: readconcern.Majority()})
+	c.SetCollectionOptions(DB_NAME, COL_NAME, ReadWriteOptions{ReadPreference: readpref.SecondaryPreferred(), MaxStaleness: 2 * time.Minute})
+	if opts := c.databaseOptions(DB_NAME); len(opts) != 1 || opts[0].ReadConcern.GetLevel() != "majority" {
+		t.Fatalf("database options Fail: %+v", opts)
+	}
+	opts := c.collectionOptions(DB_NAME, COL_NAME)
+	if len(opts) != 1 || opts[0].ReadPreference.Mode() != readpref.SecondaryPreferredMode {
+		t.Fatalf("collection options Fail: %+v", opts)
+	}
+	if staleness, _ := opts[0].ReadPreference.MaxStaleness(); staleness != 2*time.Minute {
+		t.Fatalf("max staleness Fail: %v", staleness)
+	}
+	if opts := c.collectionOptions(DB_NAME, "other"); opts != nil {
+		t.Fatalf("collection options should not leak to other collections: %+v", opts)
+	}
+
+	t.Log("[ctx options]")
+	if c.operationOptions(ctx, key) != nil {
+		t.Fatal("no operation override expected")
+	}
+	if o := c.operationOptions(WithPrimary(ctx), key); o == nil || o.ReadPreference.Mode() != readpref.PrimaryMode {
+		t.Fatalf("ctx read preference Fail: %+v", o)
+	}
+
+	t.Log("[read-your-writes pins reads to primary within the window]")
+	c.SetReadYourWrites(20 * time.Millisecond)
+	c.recordWrite(ctx, DB_NAME, COL_NAME, errors.New("write fail"))
+	if o := c.operationOptions(ctx, key); o != nil {
+		t.Fatalf("failed write should not pin reads, got %+v", o)
+	}
+	c.recordWrite(ctx, DB_NAME, COL_NAME, nil)
+	if o := c.operationOptions(ctx, key); o == nil || o.ReadPreference.Mode() != readpref.PrimaryMode {
+		t.Fatalf("read after write should use primary, got %+v", o)
+	}
+	if o := c.operationOptions(ctx, DB_NAME+".other"); o != nil {
+		t.Fatalf("write should only pin its own collection, got %+v", o)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if o := c.operationOptions(ctx, key); o != nil {
+		t.Fatalf("read after window should not be pinned, got %+v", o)
+	}
+	t.Log("TestReadWriteOptions Success")
+}
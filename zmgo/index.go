@@ -0,0 +1,390 @@
+package zmgo
+
+import (
+	"context"
+	"fmt"
+	"github.com/QuRuijie/zenDB/prom"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 索引字段类型, 对应 createIndexes 中 key 的值
+const (
+	IndexAsc      = 1
+	IndexDesc     = -1
+	IndexText     = "text"
+	Index2dSphere = "2dsphere"
+	IndexHashed   = "hashed"
+)
+
+// 索引同步动作
+const (
+	IndexActionCreate  = "create"
+	IndexActionDrop    = "drop"
+	IndexActionRebuild = "rebuild"
+)
+
+type IndexKey struct {
+	Field string
+	// Type 取 IndexAsc/IndexDesc/IndexText/Index2dSphere/IndexHashed
+	Type interface{}
+}
+
+// IndexSpec 声明式索引定义, Name 为空时按 mongo 的规则生成, 如 "name_1_age_-1"
+type IndexSpec struct {
+	Name          string
+	Keys          []IndexKey
+	Unique        bool
+	Sparse        bool
+	PartialFilter interface{}
+	// ExpireAfter TTL 索引的过期时间, 精度为秒, 0 表示不是 TTL 索引
+	ExpireAfter time.Duration
+	Collation   *options.Collation
+}
+
+// IndexAction Sync 计划中的一步, rebuild 会先 drop 再 create,
+// 相同的 key 不能同时存在两个选项不同的索引, 所以 drop 到 create 完成之间集合上没有这个索引
+type IndexAction struct {
+	Action string
+	DB     string
+	Coll   string
+	Name   string
+	Reason string
+}
+
+func (a IndexAction) String() string {
+	return fmt.Sprintf("%s %s.%s %s: %s", a.Action, a.DB, a.Coll, a.Name, a.Reason)
+}
+
+type IndexPlan []IndexAction
+
+func (p IndexPlan) String() string {
+	lines := make([]string, 0, len(p))
+	for _, a := range p {
+		lines = append(lines, a.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ----------------------------------- Index Registry -----------------------------------
+
+type collIndexes struct {
+	dbName   string
+	collName string
+	specs    []IndexSpec
+}
+
+var (
+	indexRegistry   = make(map[string]*collIndexes)
+	indexRegistryMu sync.RWMutex
+)
+
+// RegisterIndexes 声明 dbName.collName 应有的全部索引(_id 索引除外), 重复注册会覆盖,
+// Sync 时不在声明里的索引会被删除
+func RegisterIndexes(dbName, collName string, specs ...IndexSpec) {
+	indexRegistryMu.Lock()
+	defer indexRegistryMu.Unlock()
+	indexRegistry[dbName+"."+collName] = &collIndexes{dbName: dbName, collName: collName, specs: specs}
+}
+
+func registeredIndexes() []*collIndexes {
+	indexRegistryMu.RLock()
+	defer indexRegistryMu.RUnlock()
+
+	colls := make([]*collIndexes, 0, len(indexRegistry))
+	for _, ci := range indexRegistry {
+		colls = append(colls, ci)
+	}
+	sort.Slice(colls, func(i, j int) bool {
+		return colls[i].dbName+"."+colls[i].collName < colls[j].dbName+"."+colls[j].collName
+	})
+	return colls
+}
+
+// --------------------------------- Method without Client ---------------------------------------
+
+// SyncIndexes 按 GetClient(dbName) 同步所有已注册集合的索引, dryRun 为 true 时只返回计划不执行
+func SyncIndexes(ctx context.Context, dryRun bool) (IndexPlan, error) {
+	plan := IndexPlan{}
+	for _, ci := range registeredIndexes() {
+		c, err := GetClient(ci.dbName)
+		if err != nil {
+			return plan, err
+		}
+		p, err := c.SyncCollectionIndexes(ctx, ci.dbName, ci.collName, ci.specs, dryRun)
+		plan = append(plan, p...)
+		if err != nil {
+			return plan, err
+		}
+	}
+	return plan, nil
+}
+
+// --------------------------------- Method with Client --------------------------------------------
+
+// SyncIndexes 用当前客户端同步所有已注册集合的索引
+func (c *MongoClient) SyncIndexes(ctx context.Context, dryRun bool) (IndexPlan, error) {
+	plan := IndexPlan{}
+	for _, ci := range registeredIndexes() {
+		p, err := c.SyncCollectionIndexes(ctx, ci.dbName, ci.collName, ci.specs, dryRun)
+		plan = append(plan, p...)
+		if err != nil {
+			return plan, err
+		}
+	}
+	return plan, nil
+}
+
+// SyncCollectionIndexes 对比 listIndexes 的结果, 创建缺少的索引, 删除多余的索引, 重建定义变化的索引.
+// 先删除再创建, 只改了名字的索引才能以新名字创建; 重建期间查询没有这个索引可用, 大集合应在低峰期执行
+func (c *MongoClient) SyncCollectionIndexes(ctx context.Context, dbName, collName string, specs []IndexSpec, dryRun bool) (plan IndexPlan, err error) {
+	defer func(startTime int64) { c.promMonitor(ctx, startTime, "SyncIndexes", err) }(prom.NowMicrosecond())
+
+	coll := c.DbColl(dbName, collName)
+	if coll == nil {
+		return nil, fmt.Errorf("cannot find collection:%+v,%+v", dbName, collName)
+	}
+
+	existing, err := listIndexes(ctx, coll)
+	if err != nil {
+		return nil, err
+	}
+
+	plan = planIndexes(dbName, collName, specs, existing)
+	if dryRun {
+		return plan, nil
+	}
+
+	specByName := make(map[string]IndexSpec, len(specs))
+	for _, spec := range specs {
+		specByName[spec.indexName()] = spec
+	}
+	indexes := coll.Indexes()
+	for _, action := range plan {
+		if action.Action == IndexActionDrop || action.Action == IndexActionRebuild {
+			if _, err = indexes.DropOne(ctx, action.Name); err != nil {
+				return plan, fmt.Errorf("drop index %s.%s %s fail: %w", dbName, collName, action.Name, err)
+			}
+		}
+		if action.Action == IndexActionCreate || action.Action == IndexActionRebuild {
+			if _, err = indexes.CreateOne(ctx, specByName[action.Name].model()); err != nil {
+				return plan, fmt.Errorf("create index %s.%s %s fail: %w", dbName, collName, action.Name, err)
+			}
+		}
+	}
+	return plan, nil
+}
+
+// --------------------------------- Index Diff --------------------------------------------
+
+// planIndexes 按执行顺序生成计划: 先 drop 多余的索引, 再按声明顺序 create 和 rebuild
+func planIndexes(dbName, collName string, specs []IndexSpec, existing map[string]*existingIndex) IndexPlan {
+	plan := IndexPlan{}
+	desired := make(map[string]bool, len(specs))
+	for _, spec := range specs {
+		desired[spec.indexName()] = true
+	}
+
+	names := make([]string, 0, len(existing))
+	for name := range existing {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name != "_id_" && !desired[name] {
+			plan = append(plan, IndexAction{IndexActionDrop, dbName, collName, name, "index not declared"})
+		}
+	}
+
+	for _, spec := range specs {
+		name := spec.indexName()
+		idx, ok := existing[name]
+		if !ok {
+			plan = append(plan, IndexAction{IndexActionCreate, dbName, collName, name, "index not exists"})
+			continue
+		}
+		if reason := spec.diff(idx); reason != "" {
+			plan = append(plan, IndexAction{IndexActionRebuild, dbName, collName, name, reason})
+		}
+	}
+	return plan
+}
+
+type existingIndex struct {
+	Name                    string   `bson:"name"`
+	Key                     bson.D   `bson:"key"`
+	Unique                  bool     `bson:"unique"`
+	Sparse                  bool     `bson:"sparse"`
+	ExpireAfterSeconds      *int64   `bson:"expireAfterSeconds"`
+	PartialFilterExpression bson.Raw `bson:"partialFilterExpression"`
+	Weights                 bson.D   `bson:"weights"`
+	Collation               *struct {
+		Locale   string `bson:"locale"`
+		Strength int    `bson:"strength"`
+	} `bson:"collation"`
+}
+
+func listIndexes(ctx context.Context, coll *mongo.Collection) (map[string]*existingIndex, error) {
+	cursor, err := coll.Indexes().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	indexes := make(map[string]*existingIndex)
+	for cursor.Next(ctx) {
+		idx := &existingIndex{}
+		if err = cursor.Decode(idx); err != nil {
+			return nil, err
+		}
+		indexes[idx.Name] = idx
+	}
+	return indexes, cursor.Err()
+}
+
+func (spec IndexSpec) indexName() string {
+	if spec.Name != "" {
+		return spec.Name
+	}
+	parts := make([]string, 0, len(spec.Keys)*2)
+	for _, k := range spec.Keys {
+		parts = append(parts, k.Field, indexValueString(k.Type))
+	}
+	return strings.Join(parts, "_")
+}
+
+func (spec IndexSpec) model() mongo.IndexModel {
+	keys := bson.D{}
+	for _, k := range spec.Keys {
+		keys = append(keys, bson.E{Key: k.Field, Value: k.Type})
+	}
+
+	opts := options.Index().SetName(spec.indexName()).SetBackground(true)
+	if spec.Unique {
+		opts.SetUnique(true)
+	}
+	if spec.Sparse {
+		opts.SetSparse(true)
+	}
+	if spec.PartialFilter != nil {
+		opts.SetPartialFilterExpression(spec.PartialFilter)
+	}
+	if spec.ExpireAfter > 0 {
+		opts.SetExpireAfterSeconds(int32(spec.ExpireAfter / time.Second))
+	}
+	if spec.Collation != nil {
+		opts.SetCollation(spec.Collation)
+	}
+	return mongo.IndexModel{Keys: keys, Options: opts}
+}
+
+// diff 返回索引定义与线上不一致的原因, 一致时返回空字符串
+func (spec IndexSpec) diff(idx *existingIndex) string {
+	if want, got := spec.keySignature(), idx.keySignature(); want != got {
+		return fmt.Sprintf("keys %s != %s", got, want)
+	}
+	if spec.Unique != idx.Unique {
+		return fmt.Sprintf("unique %t != %t", idx.Unique, spec.Unique)
+	}
+	if spec.Sparse != idx.Sparse {
+		return fmt.Sprintf("sparse %t != %t", idx.Sparse, spec.Sparse)
+	}
+
+	var expire int64
+	if idx.ExpireAfterSeconds != nil {
+		expire = *idx.ExpireAfterSeconds
+	}
+	if want := int64(spec.ExpireAfter / time.Second); want != expire {
+		return fmt.Sprintf("expireAfterSeconds %d != %d", expire, want)
+	}
+
+	if !samePartialFilter(spec.PartialFilter, idx.PartialFilterExpression) {
+		return "partialFilterExpression changed"
+	}
+
+	switch {
+	case spec.Collation == nil && idx.Collation != nil:
+		return "collation should be removed"
+	case spec.Collation != nil && idx.Collation == nil:
+		return "collation not exists"
+	case spec.Collation != nil && (spec.Collation.Locale != idx.Collation.Locale ||
+		(spec.Collation.Strength != 0 && spec.Collation.Strength != idx.Collation.Strength)):
+		return "collation changed"
+	}
+	return ""
+}
+
+// keySignature text 索引在线上存为 {_fts: "text", _ftsx: 1} 加 weights, 所以把 text 字段单独排序比较
+func (spec IndexSpec) keySignature() string {
+	parts := make([]string, 0, len(spec.Keys))
+	textFields := make([]string, 0)
+	for _, k := range spec.Keys {
+		if k.Type == IndexText {
+			textFields = append(textFields, k.Field)
+			continue
+		}
+		parts = append(parts, k.Field+":"+indexValueString(k.Type))
+	}
+	return textSignature(parts, textFields)
+}
+
+func (idx *existingIndex) keySignature() string {
+	parts := make([]string, 0, len(idx.Key))
+	textFields := make([]string, 0)
+	for _, e := range idx.Key {
+		if e.Key == "_fts" || e.Key == "_ftsx" {
+			continue
+		}
+		parts = append(parts, e.Key+":"+indexValueString(e.Value))
+	}
+	for _, e := range idx.Weights {
+		textFields = append(textFields, e.Key)
+	}
+	return textSignature(parts, textFields)
+}
+
+func textSignature(parts, textFields []string) string {
+	if len(textFields) > 0 {
+		sort.Strings(textFields)
+		parts = append(parts, "text:"+strings.Join(textFields, ","))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func indexValueString(v interface{}) string {
+	switch n := v.(type) {
+	case int:
+		return strconv.Itoa(n)
+	case int32:
+		return strconv.Itoa(int(n))
+	case int64:
+		return strconv.FormatInt(n, 10)
+	case float64:
+		return strconv.FormatFloat(n, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func samePartialFilter(want interface{}, got bson.Raw) bool {
+	if want == nil || len(got) == 0 {
+		return want == nil && len(got) == 0
+	}
+	raw, err := bson.Marshal(want)
+	if err != nil {
+		return false
+	}
+
+	var wantM, gotM bson.M
+	if bson.Unmarshal(raw, &wantM) != nil || bson.Unmarshal(got, &gotM) != nil {
+		return false
+	}
+	return reflect.DeepEqual(wantM, gotM)
+}
@@ -0,0 +1,79 @@
+package zmgo
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"testing"
+	"time"
+)
+
+func TestIndexSpecDiff(t *testing.T) {
+	defer func() {
+		t.Log("==================TestIndexSpecDiff end====================")
+	}()
+	t.Log("==================TestIndexSpecDiff begin==================")
+
+	spec := IndexSpec{
+		Keys:        []IndexKey{{"name", IndexAsc}, {"updatedAt", IndexDesc}},
+		Unique:      true,
+		ExpireAfter: time.Hour,
+	}
+	if name := spec.indexName(); name != "name_1_updatedAt_-1" {
+		t.Fatalf("indexName Fail: %s", name)
+	}
+
+	expire := int64(3600)
+	idx := &existingIndex{
+		Name:               "name_1_updatedAt_-1",
+		Key:                bson.D{{Key: "name", Value: int32(1)}, {Key: "updatedAt", Value: float64(-1)}},
+		Unique:             true,
+		ExpireAfterSeconds: &expire,
+	}
+	if reason := spec.diff(idx); reason != "" {
+		t.Fatalf("same index should not diff: %s", reason)
+	}
+
+	idx.Unique = false
+	if reason := spec.diff(idx); reason == "" {
+		t.Fatal("unique change should diff")
+	}
+	t.Log(spec.diff(idx))
+
+	t.Log("[text index is stored as _fts/_ftsx with weights]")
+	text := IndexSpec{Keys: []IndexKey{{"title", IndexText}, {"body", IndexText}}}
+	textIdx := &existingIndex{
+		Key:     bson.D{{Key: "_fts", Value: "text"}, {Key: "_ftsx", Value: int32(1)}},
+		Weights: bson.D{{Key: "body", Value: int32(1)}, {Key: "title", Value: int32(1)}},
+	}
+	if reason := text.diff(textIdx); reason != "" {
+		t.Fatalf("same text index should not diff: %s", reason)
+	}
+	t.Log("TestIndexSpecDiff Success")
+}
+
+func TestIndexPlan(t *testing.T) {
+	defer func() {
+		t.Log("==================TestIndexPlan end====================")
+	}()
+	t.Log("==================TestIndexPlan begin==================")
+
+	t.Log("[renamed index must be dropped before created]")
+	existing := map[string]*existingIndex{
+		"_id_":   {Name: "_id_", Key: bson.D{{Key: "_id", Value: int32(1)}}},
+		"name_1": {Name: "name_1", Key: bson.D{{Key: "name", Value: int32(1)}}},
+	}
+	specs := []IndexSpec{{Name: "by_name", Keys: []IndexKey{{"name", IndexAsc}}}}
+	plan := planIndexes(DB_NAME, COL_NAME, specs, existing)
+	t.Log(plan)
+	if len(plan) != 2 ||
+		plan[0].Action != IndexActionDrop || plan[0].Name != "name_1" ||
+		plan[1].Action != IndexActionCreate || plan[1].Name != "by_name" {
+		t.Fatalf("planIndexes Fail: %+v", plan)
+	}
+
+	t.Log("[same index has nothing to do]")
+	specs = []IndexSpec{{Keys: []IndexKey{{"name", IndexAsc}}}}
+	if plan = planIndexes(DB_NAME, COL_NAME, specs, existing); len(plan) != 0 {
+		t.Fatalf("planIndexes Fail: %+v", plan)
+	}
+	t.Log("TestIndexPlan Success")
+}
@@ -0,0 +1,204 @@
+package zmgo
+
+import (
+	"context"
+	"fmt"
+	"github.com/QuRuijie/zenDB/prom"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"reflect"
+	"sync"
+)
+
+// DefaultIterBatchSize is the batch size ForEachBatch uses when batchSize <= 0
+const DefaultIterBatchSize = 1000
+
+// Iter 流式读取查询结果, 同一时间内存中只有驱动的一批数据, 用完必须 Close
+// 每批从服务器拉取的数量通过 options.FindOptions/AggregateOptions 的 BatchSize 控制
+type Iter struct {
+	ctx     context.Context
+	cursor  *mongo.Cursor
+	err     error
+	release func()
+	once    sync.Once
+}
+
+func newIter(ctx context.Context, cursor *mongo.Cursor, release func()) *Iter {
+	return &Iter{ctx: ctx, cursor: cursor, release: release}
+}
+
+// Next 移动到下一条文档, 没有更多数据或出错时返回 false, 出错原因通过 Err 获取
+func (it *Iter) Next() bool {
+	if it.err == nil && it.cursor.Next(it.ctx) {
+		return true
+	}
+	it.done()
+	return false
+}
+
+// Decode 把当前文档解码到 v
+func (it *Iter) Decode(v interface{}) error {
+	if err := it.cursor.Decode(v); err != nil {
+		it.err = err
+		return err
+	}
+	return nil
+}
+
+func (it *Iter) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.cursor.Err()
+}
+
+func (it *Iter) Close() error {
+	defer it.done()
+	return it.cursor.Close(context.Background())
+}
+
+// done 游标读完或关闭时结束客户端对这次查询的跟踪, 只执行一次
+func (it *Iter) done() {
+	it.once.Do(it.release)
+}
+
+// ForEach 对每条文档调用 fn, fn 中用 it.Decode 解码, fn 返回 error 时停止, 结束后自动 Close
+func (it *Iter) ForEach(fn func(it *Iter) error) (err error) {
+	defer it.Close()
+
+	for it.Next() {
+		if err = fn(it); err != nil {
+			return
+		}
+	}
+	return it.Err()
+}
+
+// ForEachBatch 每解码 batchSize 条文档到 result(slice 地址)后调用一次 fn, result 在批次之间复用,
+// fn 中不要持有 result 的元素, 最后一批不足 batchSize 也会调用, 结束后自动 Close
+func (it *Iter) ForEachBatch(result interface{}, batchSize int, fn func() error) (err error) {
+	defer it.Close()
+
+	resultv := reflect.ValueOf(result)
+	if resultv.Kind() != reflect.Ptr || resultv.Elem().Kind() != reflect.Slice {
+		return errors.New("result argument must be a slice address")
+	}
+	if batchSize <= 0 {
+		batchSize = DefaultIterBatchSize
+	}
+
+	slicev := resultv.Elem()
+	elemt := slicev.Type().Elem()
+	if slicev.Cap() < batchSize {
+		slicev = reflect.MakeSlice(slicev.Type(), 0, batchSize)
+	}
+	slicev = slicev.Slice(0, 0)
+
+	for it.Next() {
+		elemp := reflect.New(elemt)
+		if err = it.Decode(elemp.Interface()); err != nil {
+			return
+		}
+		slicev = reflect.Append(slicev, elemp.Elem())
+
+		if slicev.Len() >= batchSize {
+			resultv.Elem().Set(slicev)
+			if err = fn(); err != nil {
+				return
+			}
+			slicev = slicev.Slice(0, 0)
+		}
+	}
+	if err = it.Err(); err != nil {
+		return
+	}
+
+	resultv.Elem().Set(slicev)
+	if slicev.Len() > 0 {
+		return fn()
+	}
+	return nil
+}
+
+// --------------------------------- Method without Client ---------------------------------------
+
+func FindIter(ctx context.Context, dbName, collName string, query interface{}, opts ...*options.FindOptions) (*Iter, error) {
+	c, err := GetClient(dbName)
+	if err != nil {
+		return nil, err
+	}
+	return c.FindIter(ctx, dbName, collName, query, opts...)
+}
+
+func AggregateIter(ctx context.Context, dbName, collName string, pipeline interface{}, opts ...*options.AggregateOptions) (*Iter, error) {
+	c, err := GetClient(dbName)
+	if err != nil {
+		return nil, err
+	}
+	return c.AggregateIter(ctx, dbName, collName, pipeline, opts...)
+}
+
+// --------------------------------- Method with Client --------------------------------------------
+
+// FindIter 与 FindAll 相同的查询, 但返回 Iter 逐条读取, 不会把全部结果放进内存
+func (c *MongoClient) FindIter(ctx context.Context, dbName, collName string, query interface{}, opts ...*options.FindOptions) (iter *Iter, err error) {
+	defer func(startTime int64) { c.promMonitor(ctx, startTime, "FindIter", err) }(prom.NowMicrosecond())
+
+	coll := c.collection(ctx, dbName, collName)
+	if coll == nil {
+		return nil, fmt.Errorf("cannot find collection:%+v,%+v", dbName, collName)
+	}
+
+	release, err := c.beginIter(ctx, "FindIter")
+	if err != nil {
+		return
+	}
+	var cursor *mongo.Cursor
+	err = c.withRetry(ctx, "FindIter", false, func() (err error) {
+		cursor, err = coll.Find(ctx, query, opts...)
+		return
+	})
+	if err != nil {
+		release()
+		return
+	}
+	return newIter(ctx, cursor, release), nil
+}
+
+// AggregateIter 与 Aggregate 相同的聚合, 但返回 Iter 逐条读取
+func (c *MongoClient) AggregateIter(ctx context.Context, dbName, collName string, pipeline interface{}, opts ...*options.AggregateOptions) (iter *Iter, err error) {
+	defer func(startTime int64) { c.promMonitor(ctx, startTime, "AggregateIter", err) }(prom.NowMicrosecond())
+
+	coll := c.collection(ctx, dbName, collName)
+	if coll == nil {
+		return nil, fmt.Errorf("cannot find collection:%+v,%+v", dbName, collName)
+	}
+
+	release, err := c.beginIter(ctx, "AggregateIter")
+	if err != nil {
+		return
+	}
+	var cursor *mongo.Cursor
+	err = c.withRetry(ctx, "AggregateIter", false, func() (err error) {
+		cursor, err = coll.Aggregate(ctx, pipeline, opts...)
+		return
+	})
+	if err != nil {
+		release()
+		return
+	}
+	return newIter(ctx, cursor, release), nil
+}
+
+// beginIter 游标打开到读完或 Close 之前都算进行中的操作, Shutdown 会等待游标结束.
+// 事务内的操作由 WithTransaction 整体记录, 与 withRetry 相同
+func (c *MongoClient) beginIter(ctx context.Context, method string) (release func(), err error) {
+	if inTransaction(ctx) {
+		return func() {}, nil
+	}
+	if err = c.life.Begin(method); err != nil {
+		return nil, err
+	}
+	return func() { c.life.End(method) }, nil
+}
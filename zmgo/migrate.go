@@ -0,0 +1,306 @@
+package zmgo
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/QuRuijie/zenDB/prom"
+	"github.com/Zentertain/zenlog"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// MigrationCollection 每个库中记录已执行迁移的集合
+	MigrationCollection = "zmgo_migrations"
+	// MigrationLockCollection 每个库中迁移锁所在的集合
+	MigrationLockCollection = "zmgo_migrations_lock"
+
+	migrationLockID = "lock"
+)
+
+var (
+	// MigrationLockTTL 迁移锁的租期, 迁移执行期间每隔 1/3 租期续期一次, 持锁实例崩溃后超过该时间锁会被其他实例接管
+	MigrationLockTTL = 30 * time.Minute
+
+	ErrMigrationLocked   = errors.New("migration is running on another instance")
+	ErrMigrationChecksum = errors.New("migration checksum mismatch")
+	ErrMigrationNoDown   = errors.New("migration has no down function")
+
+	migrations   = make(map[int64]*Migration)
+	migrationsMu sync.RWMutex
+)
+
+// MigrateFunc 在 dbName 库上执行一次迁移, c 为该项目对应的客户端
+type MigrateFunc func(ctx context.Context, c *MongoClient, dbName string) error
+
+// Migration 一次数据迁移, 按 Version 从小到大执行
+type Migration struct {
+	Version int64
+	Name    string
+	Up      MigrateFunc
+	// Down 回滚, 可以为空, 为空时该迁移不能回滚
+	Down MigrateFunc
+	// Checksum 迁移内容的摘要, 已执行迁移的摘要变化时 Migrate 会返回 ErrMigrationChecksum.
+	// 为空时只由 Version 和 Name 计算, 只能发现已执行的迁移被改名, 发现不了 Up 内容的修改,
+	// 需要检查内容时由调用方设置, 例如迁移内容的版本号或迁移源码的摘要
+	Checksum string
+}
+
+// MigrationRecord MigrationCollection 中的一条记录
+type MigrationRecord struct {
+	Version   int64     `bson:"_id"`
+	Name      string    `bson:"name"`
+	Checksum  string    `bson:"checksum"`
+	AppliedAt time.Time `bson:"appliedAt"`
+}
+
+type migrationLock struct {
+	ID       string    `bson:"_id"`
+	Owner    string    `bson:"owner"`
+	ExpireAt time.Time `bson:"expireAt"`
+}
+
+// RegisterMigration 注册迁移, 一般在 init 中调用, Version 重复时 panic
+func RegisterMigration(m Migration) {
+	if m.Up == nil {
+		panic(fmt.Sprintf("migration %d %s has no up function", m.Version, m.Name))
+	}
+
+	migrationsMu.Lock()
+	defer migrationsMu.Unlock()
+	if old, ok := migrations[m.Version]; ok {
+		panic(fmt.Sprintf("migration version %d registered twice: %s, %s", m.Version, old.Name, m.Name))
+	}
+	if m.Checksum == "" {
+		sum := sha256.Sum256([]byte(fmt.Sprintf("%d:%s", m.Version, m.Name)))
+		m.Checksum = hex.EncodeToString(sum[:])
+	}
+	migrations[m.Version] = &m
+}
+
+func sortedMigrations() []*Migration {
+	migrationsMu.RLock()
+	defer migrationsMu.RUnlock()
+
+	list := make([]*Migration, 0, len(migrations))
+	for _, m := range migrations {
+		list = append(list, m)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list
+}
+
+// --------------------------------- Method without Client ---------------------------------------
+
+// MigrateAll 对 Clients() 中的每个项目执行迁移, commonProjects 为使用 CommonClient 的项目,
+// 因为 CommonClient 服务的项目无法枚举, 需要调用方传入
+func MigrateAll(ctx context.Context, commonProjects ...string) error {
+	if !Initialized() {
+		return errors.New("Mongo Client is not init!")
+	}
+
+	var projects []string
+	for _, key := range Clients().Keys() {
+		if key != DefaultClientKey {
+			projects = append(projects, key)
+		}
+	}
+	return MigrateProjects(ctx, append(projects, commonProjects...)...)
+}
+
+// MigrateProjects 按 GetClient(projectId) 对每个项目库执行迁移, 某个项目失败时立即返回
+func MigrateProjects(ctx context.Context, projectIds ...string) error {
+	for _, projectId := range projectIds {
+		c, err := GetClient(projectId)
+		if err != nil {
+			return err
+		}
+		if _, err = c.Migrate(ctx, projectId); err != nil {
+			return fmt.Errorf("migrate project %s fail: %w", projectId, err)
+		}
+	}
+	return nil
+}
+
+// --------------------------------- Method with Client --------------------------------------------
+
+// Migrate 在 dbName 上按顺序执行所有未执行的迁移, 返回本次执行的迁移记录
+func (c *MongoClient) Migrate(ctx context.Context, dbName string) (applied []MigrationRecord, err error) {
+	defer func(startTime int64) { c.promMonitor(ctx, startTime, "Migrate", err) }(prom.NowMicrosecond())
+
+	owner, err := c.acquireMigrationLock(ctx, dbName)
+	if err != nil {
+		return nil, err
+	}
+	defer c.releaseMigrationLock(dbName, owner)
+	lockCtx, stop := c.holdMigrationLock(ctx, dbName, owner)
+	defer stop()
+
+	records, err := c.MigrationRecords(lockCtx, dbName)
+	if err != nil {
+		return nil, err
+	}
+	done := make(map[int64]MigrationRecord, len(records))
+	for _, r := range records {
+		done[r.Version] = r
+	}
+
+	applied = make([]MigrationRecord, 0)
+	for _, m := range sortedMigrations() {
+		if r, ok := done[m.Version]; ok {
+			if r.Checksum != m.Checksum {
+				return applied, fmt.Errorf("%w: %s version %d %s", ErrMigrationChecksum, dbName, m.Version, m.Name)
+			}
+			continue
+		}
+
+		zenlog.Info("zmgo migrate %s up %d %s", dbName, m.Version, m.Name)
+		if err = m.Up(lockCtx, c, dbName); err != nil {
+			return applied, fmt.Errorf("migration %d %s up fail: %w", m.Version, m.Name, err)
+		}
+
+		record := MigrationRecord{Version: m.Version, Name: m.Name, Checksum: m.Checksum, AppliedAt: time.Now()}
+		if _, err = c.InsertOneCtx(lockCtx, dbName, MigrationCollection, record); err != nil {
+			return applied, err
+		}
+		applied = append(applied, record)
+	}
+	return applied, nil
+}
+
+// MigrateDown 按倒序回滚 dbName 上所有 Version 大于 target 的迁移
+func (c *MongoClient) MigrateDown(ctx context.Context, dbName string, target int64) (reverted []MigrationRecord, err error) {
+	defer func(startTime int64) { c.promMonitor(ctx, startTime, "MigrateDown", err) }(prom.NowMicrosecond())
+
+	owner, err := c.acquireMigrationLock(ctx, dbName)
+	if err != nil {
+		return nil, err
+	}
+	defer c.releaseMigrationLock(dbName, owner)
+	lockCtx, stop := c.holdMigrationLock(ctx, dbName, owner)
+	defer stop()
+
+	records, err := c.MigrationRecords(lockCtx, dbName)
+	if err != nil {
+		return nil, err
+	}
+
+	registered := make(map[int64]*Migration)
+	for _, m := range sortedMigrations() {
+		registered[m.Version] = m
+	}
+
+	reverted = make([]MigrationRecord, 0)
+	for i := len(records) - 1; i >= 0 && records[i].Version > target; i-- {
+		r := records[i]
+		m, ok := registered[r.Version]
+		if !ok || m.Down == nil {
+			return reverted, fmt.Errorf("%w: %s version %d %s", ErrMigrationNoDown, dbName, r.Version, r.Name)
+		}
+
+		zenlog.Info("zmgo migrate %s down %d %s", dbName, m.Version, m.Name)
+		if err = m.Down(lockCtx, c, dbName); err != nil {
+			return reverted, fmt.Errorf("migration %d %s down fail: %w", m.Version, m.Name, err)
+		}
+		if err = c.DeleteOneCtx(lockCtx, dbName, MigrationCollection, bson.M{"_id": r.Version}); err != nil {
+			return reverted, err
+		}
+		reverted = append(reverted, r)
+	}
+	return reverted, nil
+}
+
+// MigrationRecords 返回 dbName 上已执行的迁移, 按 Version 升序
+func (c *MongoClient) MigrationRecords(ctx context.Context, dbName string) ([]MigrationRecord, error) {
+	records := make([]MigrationRecord, 0)
+	opts := options.Find().SetSort(bson.M{"_id": 1})
+	err := c.FindAllCtx(ctx, &records, dbName, MigrationCollection, bson.M{}, opts)
+	return records, err
+}
+
+// --------------------------------- Migration Lock --------------------------------------------
+
+// acquireMigrationLock 锁不存在或已过期时抢占, 被其他实例持有时 upsert 会触发 _id 冲突
+func (c *MongoClient) acquireMigrationLock(ctx context.Context, dbName string) (string, error) {
+	owner, err := migrationOwner()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	filter := bson.M{"_id": migrationLockID, "expireAt": bson.M{"$lt": now}}
+	update := bson.M{"$set": bson.M{"owner": owner, "expireAt": now.Add(MigrationLockTTL)}}
+	opts := options.FindOneAndUpdate().SetUpsert(true)
+
+	err = c.FindOneAndUpdateCtx(ctx, dbName, MigrationLockCollection, filter, update, opts)
+	if err == nil || err == mongo.ErrNoDocuments {
+		return owner, nil
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		return "", ErrMigrationLocked
+	}
+	return "", err
+}
+
+// holdMigrationLock 迁移执行期间定期续期, 锁已经被其他实例接管时取消返回的 ctx, 正在执行的迁移随之失败.
+// 返回的 stop 停止续期
+func (c *MongoClient) holdMigrationLock(ctx context.Context, dbName, owner string) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(MigrationLockTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			filter := bson.M{"_id": migrationLockID, "owner": owner}
+			update := bson.M{"$set": bson.M{"expireAt": time.Now().Add(MigrationLockTTL)}}
+			res, err := c.UpdateOneWithResult(context.Background(), dbName, MigrationLockCollection, filter, update)
+			if err != nil {
+				zenlog.Warn("zmgo renew migration lock %s fail: %+v", dbName, err)
+				continue
+			}
+			if res.MatchedCount == 0 {
+				zenlog.Error("zmgo migration lock %s taken over by another instance, cancel migration", dbName)
+				cancel()
+				return
+			}
+		}
+	}()
+	return ctx, func() {
+		close(done)
+		<-stopped
+		cancel()
+	}
+}
+
+func (c *MongoClient) releaseMigrationLock(dbName, owner string) {
+	filter := bson.M{"_id": migrationLockID, "owner": owner}
+	if err := c.DeleteOneCtx(context.Background(), dbName, MigrationLockCollection, filter); err != nil {
+		zenlog.Error("zmgo release migration lock %s fail: %+v", dbName, err)
+	}
+}
+
+func migrationOwner() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(b)), nil
+}
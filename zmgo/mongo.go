@@ -5,6 +5,8 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
+	"github.com/QuRuijie/zenDB/breaker"
+	"github.com/QuRuijie/zenDB/lifecycle"
 	"github.com/QuRuijie/zenDB/prom"
 	"github.com/Zentertain/zenlog"
 	"github.com/pkg/errors"
@@ -15,6 +17,7 @@ import (
 	"io/ioutil"
 	"reflect"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -32,17 +35,34 @@ const (
 )
 
 var (
+	// ClientMap 和 CommonClient 是 Clients() 的只读快照, 客户端更新时整体替换, 新代码请使用 Clients().
+	// 直接读取这三个变量只在 Init 之后, 开始并发使用和运行时更新客户端之前是安全的,
+	// 运行时请使用 GetClientMap, GetCommonClient 和 Initialized
 	ClientMap    map[string]*MongoClient
 	CommonClient *MongoClient
 	IsInit       bool
+
+	// DefaultReadPreference 通过 uri 创建的客户端默认的读偏好, 可以用 SetDatabaseOptions 等按库和集合覆盖
+	DefaultReadPreference = readpref.Nearest()
 )
 
 // ----------------------------------- Wrapper Mongo Client -----------------------------------
 
 type MongoClient struct {
-	client *mongo.Client
-	dbs    map[string]*mongo.Database
-	prom   bool
+	client  *mongo.Client
+	dbsMu   sync.RWMutex
+	dbs     map[string]*mongo.Database
+	prom    bool
+	retry   *RetryPolicy
+	breaker *breaker.Breaker
+	life    *lifecycle.Tracker
+
+	// 按库和集合覆盖的读写选项, 以及 read-your-writes 的状态, 见 consistency.go
+	optsMu     sync.RWMutex
+	dbOpts     map[string]*ReadWriteOptions
+	collOpts   map[string]*ReadWriteOptions
+	rywWindow  time.Duration
+	lastWrites sync.Map
 }
 
 // NewMongoClient create MongoClient use default options.ClientOptions
@@ -97,7 +117,7 @@ func NewClient(prom bool, opts ...*options.ClientOptions) (*MongoClient, error)
 		return nil, fmt.Errorf("ping mongo fail: %+v", err)
 	}
 
-	return &MongoClient{client: client, dbs: make(map[string]*mongo.Database), prom: prom}, nil
+	return &MongoClient{client: client, dbs: make(map[string]*mongo.Database), prom: prom, life: lifecycle.NewTracker("mongo")}, nil
 }
 
 // GetCustomTLSConfig 获取TLS证书
@@ -124,7 +144,7 @@ func getOptions(uri string) *options.ClientOptions {
 	opts := options.Client()
 	opts.ApplyURI(uri)
 	opts.SetMaxPoolSize(MongoConnPoolLimit)
-	opts.SetReadPreference(readpref.Nearest())
+	opts.SetReadPreference(DefaultReadPreference)
 	return opts
 }
 
@@ -147,37 +167,65 @@ func Collection(projectId, collection string) *mongo.Collection {
 }
 
 func (c *MongoClient) Database(name string) *mongo.Database {
-	if db, ok := c.dbs[name]; ok {
+	c.dbsMu.RLock()
+	db, ok := c.dbs[name]
+	c.dbsMu.RUnlock()
+	if ok {
 		return db
 	}
 
 	// create db4
-	db := c.client.Database(name)
+	c.dbsMu.Lock()
+	defer c.dbsMu.Unlock()
+	if db, ok = c.dbs[name]; ok {
+		return db
+	}
+	db = c.client.Database(name, c.databaseOptions(name)...)
+	if c.dbs == nil {
+		c.dbs = make(map[string]*mongo.Database)
+	}
 	c.dbs[name] = db
 
 	return db
 }
 
 func (c *MongoClient) DbColl(dbName, coll string) *mongo.Collection {
-	return c.Database(dbName).Collection(coll)
+	return c.Database(dbName).Collection(coll, c.collectionOptions(dbName, coll)...)
 }
 
-// Init ClientMap and CommonClient
+// Init ClientMap and CommonClient, 运行时更新客户端使用 Clients()
 func Init(adds map[string]string, prom bool) {
-	ClientMap = make(map[string]*MongoClient, len(adds))
-	for key, uri := range adds {
-		client, err := NewMongoClientWithProm(uri, prom)
-		if err != nil {
-			panic(err)
-		}
-
-		if key == "default" {
-			CommonClient = client
-		} else {
-			ClientMap[key] = client
-		}
+	r := Clients()
+	r.NewClient = func(uri string) (*MongoClient, error) {
+		return NewMongoClientWithProm(uri, prom)
+	}
+	if err := r.Apply(context.Background(), adds); err != nil {
+		panic(err)
 	}
+	registryMu.Lock()
 	IsInit = true
+	registryMu.Unlock()
+}
+
+// Initialized 并发安全地读取 IsInit
+func Initialized() bool {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	return IsInit
+}
+
+// GetClientMap 并发安全地读取 ClientMap, 返回的 map 不能修改
+func GetClientMap() map[string]*MongoClient {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	return ClientMap
+}
+
+// GetCommonClient 并发安全地读取 CommonClient
+func GetCommonClient() *MongoClient {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	return CommonClient
 }
 
 // GetClientFunc You can select your client by yourself
@@ -185,17 +233,12 @@ type GetClientFunc func(projectId string) (*MongoClient, error)
 
 // GetClient default GetClient func is get client from ClientMap
 var GetClient GetClientFunc = func(projectId string) (client *MongoClient, err error) {
-	if !IsInit {
+	if !Initialized() {
 		err = errors.New("Mongo Client is not init!")
 		return
 	}
 
-	if c, ok := ClientMap[projectId]; ok {
-		client = c
-	} else {
-		client = CommonClient
-	}
-	return
+	return Clients().Lookup(projectId)
 }
 
 //SetFindClient If you want select client by yourself you can do it
@@ -206,151 +249,331 @@ func SetFindClient(Func GetClientFunc) {
 // --------------------------------- Method without Client ---------------------------------------
 
 func FindOne(result interface{}, proj string, collName string, query interface{}, opts ...*options.FindOneOptions) error {
+	return FindOneCtx(context.Background(), result, proj, collName, query, opts...)
+}
+
+func FindAll(result interface{}, dbName, collName string, query interface{}, opts ...*options.FindOptions) error {
+	return FindAllCtx(context.Background(), result, dbName, collName, query, opts...)
+}
+
+func FindOneAndUpdate(dbName, collName string, filter interface{}, update interface{}, opts ...*options.FindOneAndUpdateOptions) error {
+	return FindOneAndUpdateCtx(context.Background(), dbName, collName, filter, update, opts...)
+}
+
+func FindOneAndDelete(dbName, collName string, filter interface{}, opts ...*options.FindOneAndDeleteOptions) error {
+	return FindOneAndDeleteCtx(context.Background(), dbName, collName, filter, opts...)
+}
+
+func UpdateOne(dbName, collName string, filter interface{}, update interface{}, opts ...*options.UpdateOptions) error {
+	return UpdateOneCtx(context.Background(), dbName, collName, filter, update, opts...)
+}
+
+func UpdateAll(dbName, collName string, filter interface{}, update interface{}, opts ...*options.UpdateOptions) error {
+	return UpdateAllCtx(context.Background(), dbName, collName, filter, update, opts...)
+}
+
+func UpsertOne(dbName, collName string, filter interface{}, update interface{}, opts ...*options.UpdateOptions) error {
+	return UpsertOneCtx(context.Background(), dbName, collName, filter, update, opts...)
+}
+
+func InsertOne(dbName, collName string, document interface{}, opts ...*options.InsertOneOptions) (interface{}, error) {
+	return InsertOneCtx(context.Background(), dbName, collName, document, opts...)
+}
+
+func InsertMany(dbName, collName string, documents []interface{}, opts ...*options.InsertManyOptions) (*mongo.InsertManyResult, error) {
+	return InsertManyCtx(context.Background(), dbName, collName, documents, opts...)
+}
+
+func DeleteOne(dbName, collName string, filter interface{}, opts ...*options.DeleteOptions) error {
+	return DeleteOneCtx(context.Background(), dbName, collName, filter, opts...)
+}
+
+func DeleteMany(dbName, collName string, filter interface{}, opts ...*options.DeleteOptions) error {
+	return DeleteManyCtx(context.Background(), dbName, collName, filter, opts...)
+}
+
+func Count(dbName, collName string, filter interface{}, opts ...*options.CountOptions) (int64, error) {
+	return CountCtx(context.Background(), dbName, collName, filter, opts...)
+}
+
+func Aggregate(result interface{}, dbName, collName string, pipeline interface{}, opts ...*options.AggregateOptions) error {
+	return AggregateCtx(context.Background(), result, dbName, collName, pipeline, opts...)
+}
+
+func BulkWrite(dbName, collName string, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) error {
+	return BulkWriteCtx(context.Background(), dbName, collName, models, opts...)
+}
+
+// --------------------------------- Method without Client (Context) -------------------------------
+
+func FindOneCtx(ctx context.Context, result interface{}, proj string, collName string, query interface{}, opts ...*options.FindOneOptions) error {
 	c, err := GetClient(proj)
 	if err != nil {
 		return err
 	}
-	return c.FindOne(result, proj, collName, query, opts...)
+	return c.FindOneCtx(ctx, result, proj, collName, query, opts...)
 }
 
-func FindAll(result interface{}, dbName, collName string, query interface{}, opts ...*options.FindOptions) error {
+func FindAllCtx(ctx context.Context, result interface{}, dbName, collName string, query interface{}, opts ...*options.FindOptions) error {
 	c, err := GetClient(dbName)
 	if err != nil {
 		return err
 	}
-	return c.FindAll(result, dbName, collName, query, opts...)
+	return c.FindAllCtx(ctx, result, dbName, collName, query, opts...)
 }
 
-func FindOneAndUpdate(dbName, collName string, filter interface{}, update interface{}, opts ...*options.FindOneAndUpdateOptions) error {
+func FindOneAndUpdateCtx(ctx context.Context, dbName, collName string, filter interface{}, update interface{}, opts ...*options.FindOneAndUpdateOptions) error {
 	c, err := GetClient(dbName)
 	if err != nil {
 		return err
 	}
-	return c.FindOneAndUpdate(dbName, collName, filter, update, opts...)
+	return c.FindOneAndUpdateCtx(ctx, dbName, collName, filter, update, opts...)
 }
 
-func FindOneAndDelete(dbName, collName string, filter interface{}, opts ...*options.FindOneAndDeleteOptions) error {
+func FindOneAndDeleteCtx(ctx context.Context, dbName, collName string, filter interface{}, opts ...*options.FindOneAndDeleteOptions) error {
 	c, err := GetClient(dbName)
 	if err != nil {
 		return err
 	}
-	return c.FindOneAndDelete(dbName, collName, filter, opts...)
+	return c.FindOneAndDeleteCtx(ctx, dbName, collName, filter, opts...)
 }
 
-func UpdateOne(dbName, collName string, filter interface{}, update interface{}, opts ...*options.UpdateOptions) error {
+func UpdateOneCtx(ctx context.Context, dbName, collName string, filter interface{}, update interface{}, opts ...*options.UpdateOptions) error {
 	c, err := GetClient(dbName)
 	if err != nil {
 		return err
 	}
-	return c.UpdateOne(dbName, collName, filter, update, opts...)
+	return c.UpdateOneCtx(ctx, dbName, collName, filter, update, opts...)
 }
 
-func UpdateAll(dbName, collName string, filter interface{}, update interface{}, opts ...*options.UpdateOptions) error {
+func UpdateAllCtx(ctx context.Context, dbName, collName string, filter interface{}, update interface{}, opts ...*options.UpdateOptions) error {
 	c, err := GetClient(dbName)
 	if err != nil {
 		return err
 	}
-	return c.UpdateAll(dbName, collName, filter, update, opts...)
+	return c.UpdateAllCtx(ctx, dbName, collName, filter, update, opts...)
 }
 
-func UpsertOne(dbName, collName string, filter interface{}, update interface{}, opts ...*options.UpdateOptions) error {
+func UpsertOneCtx(ctx context.Context, dbName, collName string, filter interface{}, update interface{}, opts ...*options.UpdateOptions) error {
 	c, err := GetClient(dbName)
 	if err != nil {
 		return err
 	}
-	return c.UpsertOne(dbName, collName, filter, update, opts...)
+	return c.UpsertOneCtx(ctx, dbName, collName, filter, update, opts...)
 }
 
-func InsertOne(dbName, collName string, document interface{}, opts ...*options.InsertOneOptions) (interface{}, error) {
+func InsertOneCtx(ctx context.Context, dbName, collName string, document interface{}, opts ...*options.InsertOneOptions) (interface{}, error) {
 	c, err := GetClient(dbName)
 	if err != nil {
 		return nil, err
 	}
-	return c.InsertOne(dbName, collName, document, opts...)
+	return c.InsertOneCtx(ctx, dbName, collName, document, opts...)
 }
 
-func InsertMany(dbName, collName string, documents []interface{}, opts ...*options.InsertManyOptions) (*mongo.InsertManyResult, error) {
+func InsertManyCtx(ctx context.Context, dbName, collName string, documents []interface{}, opts ...*options.InsertManyOptions) (*mongo.InsertManyResult, error) {
 	c, err := GetClient(dbName)
 	if err != nil {
 		return nil, err
 	}
-	return c.InsertMany(dbName, collName, documents, opts...)
+	return c.InsertManyCtx(ctx, dbName, collName, documents, opts...)
 }
 
-func DeleteOne(dbName, collName string, filter interface{}, opts ...*options.DeleteOptions) error {
+func DeleteOneCtx(ctx context.Context, dbName, collName string, filter interface{}, opts ...*options.DeleteOptions) error {
 	c, err := GetClient(dbName)
 	if err != nil {
 		return err
 	}
-	return c.DeleteOne(dbName, collName, filter, opts...)
+	return c.DeleteOneCtx(ctx, dbName, collName, filter, opts...)
 }
 
-func DeleteMany(dbName, collName string, filter interface{}, opts ...*options.DeleteOptions) error {
-
+func DeleteManyCtx(ctx context.Context, dbName, collName string, filter interface{}, opts ...*options.DeleteOptions) error {
 	c, err := GetClient(dbName)
 	if err != nil {
 		return err
 	}
-	return c.DeleteMany(dbName, collName, filter, opts...)
+	return c.DeleteManyCtx(ctx, dbName, collName, filter, opts...)
 }
 
-func Count(dbName, collName string, filter interface{}, opts ...*options.CountOptions) (int64, error) {
+func CountCtx(ctx context.Context, dbName, collName string, filter interface{}, opts ...*options.CountOptions) (int64, error) {
 	c, err := GetClient(dbName)
 	if err != nil {
 		return -1, err
 	}
-	return c.Count(dbName, collName, filter, opts...)
+	return c.CountCtx(ctx, dbName, collName, filter, opts...)
 }
 
-func Aggregate(result interface{}, dbName, collName string, pipeline interface{}, opts ...*options.AggregateOptions) error {
+func AggregateCtx(ctx context.Context, result interface{}, dbName, collName string, pipeline interface{}, opts ...*options.AggregateOptions) error {
 	c, err := GetClient(dbName)
 	if err != nil {
 		return err
 	}
-	return c.Aggregate(result, dbName, collName, pipeline, opts...)
+	return c.AggregateCtx(ctx, result, dbName, collName, pipeline, opts...)
 }
 
-func BulkWrite(dbName, collName string, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) error {
+func BulkWriteCtx(ctx context.Context, dbName, collName string, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) error {
+	c, err := GetClient(dbName)
+	if err != nil {
+		return err
+	}
+	return c.BulkWriteCtx(ctx, dbName, collName, models, opts...)
+}
+
+// --------------------------------- Method without Client (Result) --------------------------------
+
+func FindOneAndUpdateDecode(ctx context.Context, result interface{}, dbName, collName string, filter interface{}, update interface{}, opts ...*options.FindOneAndUpdateOptions) error {
+	c, err := GetClient(dbName)
+	if err != nil {
+		return err
+	}
+	return c.FindOneAndUpdateDecode(ctx, result, dbName, collName, filter, update, opts...)
+}
+
+func FindOneAndDeleteDecode(ctx context.Context, result interface{}, dbName, collName string, filter interface{}, opts ...*options.FindOneAndDeleteOptions) error {
 	c, err := GetClient(dbName)
 	if err != nil {
 		return err
 	}
-	return c.BulkWrite(dbName, collName, models, opts...)
+	return c.FindOneAndDeleteDecode(ctx, result, dbName, collName, filter, opts...)
+}
+
+func UpdateOneWithResult(ctx context.Context, dbName, collName string, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*WriteResult, error) {
+	c, err := GetClient(dbName)
+	if err != nil {
+		return nil, err
+	}
+	return c.UpdateOneWithResult(ctx, dbName, collName, filter, update, opts...)
+}
+
+func UpdateAllWithResult(ctx context.Context, dbName, collName string, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*WriteResult, error) {
+	c, err := GetClient(dbName)
+	if err != nil {
+		return nil, err
+	}
+	return c.UpdateAllWithResult(ctx, dbName, collName, filter, update, opts...)
+}
+
+func UpsertOneWithResult(ctx context.Context, dbName, collName string, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*WriteResult, error) {
+	c, err := GetClient(dbName)
+	if err != nil {
+		return nil, err
+	}
+	return c.UpsertOneWithResult(ctx, dbName, collName, filter, update, opts...)
+}
+
+func DeleteOneWithResult(ctx context.Context, dbName, collName string, filter interface{}, opts ...*options.DeleteOptions) (*WriteResult, error) {
+	c, err := GetClient(dbName)
+	if err != nil {
+		return nil, err
+	}
+	return c.DeleteOneWithResult(ctx, dbName, collName, filter, opts...)
+}
+
+func DeleteManyWithResult(ctx context.Context, dbName, collName string, filter interface{}, opts ...*options.DeleteOptions) (*WriteResult, error) {
+	c, err := GetClient(dbName)
+	if err != nil {
+		return nil, err
+	}
+	return c.DeleteManyWithResult(ctx, dbName, collName, filter, opts...)
 }
 
 // --------------------------------- Method with Client --------------------------------------------
 
-func (c *MongoClient) FindOne(result interface{}, dbName, collName string, query interface{}, opts ...*options.FindOneOptions) (err error) {
-	defer func(startTime int64) { c.promMonitor(startTime, "FindOne", err) }(prom.NowMicrosecond())
+func (c *MongoClient) FindOne(result interface{}, dbName, collName string, query interface{}, opts ...*options.FindOneOptions) error {
+	return c.FindOneCtx(context.Background(), result, dbName, collName, query, opts...)
+}
+
+func (c *MongoClient) FindAll(result interface{}, dbName, collName string, query interface{}, opts ...*options.FindOptions) error {
+	return c.FindAllCtx(context.Background(), result, dbName, collName, query, opts...)
+}
+
+func (c *MongoClient) FindOneAndUpdate(dbName, collName string, filter interface{}, update interface{}, opts ...*options.FindOneAndUpdateOptions) error {
+	return c.FindOneAndUpdateCtx(context.Background(), dbName, collName, filter, update, opts...)
+}
+
+func (c *MongoClient) FindOneAndDelete(dbName, collName string, filter interface{}, opts ...*options.FindOneAndDeleteOptions) error {
+	return c.FindOneAndDeleteCtx(context.Background(), dbName, collName, filter, opts...)
+}
+
+func (c *MongoClient) UpdateOne(dbName, collName string, filter interface{}, update interface{}, opts ...*options.UpdateOptions) error {
+	return c.UpdateOneCtx(context.Background(), dbName, collName, filter, update, opts...)
+}
+
+func (c *MongoClient) UpdateAll(dbName, collName string, filter interface{}, update interface{}, opts ...*options.UpdateOptions) error {
+	return c.UpdateAllCtx(context.Background(), dbName, collName, filter, update, opts...)
+}
+
+func (c *MongoClient) UpsertOne(dbName, collName string, filter interface{}, update interface{}, opts ...*options.UpdateOptions) error {
+	return c.UpsertOneCtx(context.Background(), dbName, collName, filter, update, opts...)
+}
+
+func (c *MongoClient) InsertOne(dbName, collName string, document interface{}, opts ...*options.InsertOneOptions) (interface{}, error) {
+	return c.InsertOneCtx(context.Background(), dbName, collName, document, opts...)
+}
+
+func (c *MongoClient) InsertMany(dbName, collName string, documents []interface{}, opts ...*options.InsertManyOptions) (*mongo.InsertManyResult, error) {
+	return c.InsertManyCtx(context.Background(), dbName, collName, documents, opts...)
+}
+
+func (c *MongoClient) DeleteOne(dbName, collName string, filter interface{}, opts ...*options.DeleteOptions) error {
+	return c.DeleteOneCtx(context.Background(), dbName, collName, filter, opts...)
+}
 
-	coll := c.DbColl(dbName, collName)
+func (c *MongoClient) DeleteMany(dbName, collName string, filter interface{}, opts ...*options.DeleteOptions) error {
+	return c.DeleteManyCtx(context.Background(), dbName, collName, filter, opts...)
+}
+
+func (c *MongoClient) Count(dbName, collName string, filter interface{}, opts ...*options.CountOptions) (int64, error) {
+	return c.CountCtx(context.Background(), dbName, collName, filter, opts...)
+}
+
+func (c *MongoClient) Aggregate(result interface{}, dbName, collName string, pipeline interface{}, opts ...*options.AggregateOptions) error {
+	return c.AggregateCtx(context.Background(), result, dbName, collName, pipeline, opts...)
+}
+
+func (c *MongoClient) BulkWrite(dbName, collName string, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) error {
+	return c.BulkWriteCtx(context.Background(), dbName, collName, models, opts...)
+}
+
+// --------------------------------- Method with Client (Context) ----------------------------------
+
+func (c *MongoClient) FindOneCtx(ctx context.Context, result interface{}, dbName, collName string, query interface{}, opts ...*options.FindOneOptions) (err error) {
+	defer func(startTime int64) { c.promMonitor(ctx, startTime, "FindOne", err) }(prom.NowMicrosecond())
+
+	coll := c.collection(ctx, dbName, collName)
 	if coll == nil {
 		return fmt.Errorf("cannot find collection: %+v, %+v", dbName, collName)
 	}
 
-	findResult := coll.FindOne(context.Background(), query, opts...)
-	if findResult.Err() != nil {
+	var findResult *mongo.SingleResult
+	err = c.withRetry(ctx, "FindOne", false, func() error {
+		findResult = coll.FindOne(ctx, query, opts...)
 		return findResult.Err()
+	})
+	if err != nil {
+		return
 	}
 
 	return findResult.Decode(result)
 }
 
-func (c *MongoClient) FindAll(result interface{}, dbName, collName string, query interface{}, opts ...*options.FindOptions) (err error) {
-	defer func(startTime int64) { c.promMonitor(startTime, "FindAll", err) }(prom.NowMicrosecond())
+func (c *MongoClient) FindAllCtx(ctx context.Context, result interface{}, dbName, collName string, query interface{}, opts ...*options.FindOptions) (err error) {
+	defer func(startTime int64) { c.promMonitor(ctx, startTime, "FindAll", err) }(prom.NowMicrosecond())
 
 	resultv := reflect.ValueOf(result)
 	if resultv.Kind() != reflect.Ptr || resultv.Elem().Kind() != reflect.Slice {
 		return errors.New("result argument must be a slice address")
 	}
 
-	coll := c.DbColl(dbName, collName)
+	coll := c.collection(ctx, dbName, collName)
 	if coll == nil {
 		return fmt.Errorf("cannot find collection:%+v,%+v", dbName, collName)
 	}
 
-	ctx := context.Background()
-	cursor, err := coll.Find(ctx, query, opts...)
+	var cursor *mongo.Cursor
+	err = c.withRetry(ctx, "FindAll", false, func() (err error) {
+		cursor, err = coll.Find(ctx, query, opts...)
+		return
+	})
 	if err != nil {
 		return
 	}
@@ -358,164 +581,307 @@ func (c *MongoClient) FindAll(result interface{}, dbName, collName string, query
 	return DecodeAll(cursor, ctx, result)
 }
 
-func (c *MongoClient) FindOneAndUpdate(dbName, collName string, filter interface{}, update interface{}, opts ...*options.FindOneAndUpdateOptions) (err error) {
-	defer func(startTime int64) { c.promMonitor(startTime, "FindOneAndUpdate", err) }(prom.NowMicrosecond())
+func (c *MongoClient) FindOneAndUpdateCtx(ctx context.Context, dbName, collName string, filter interface{}, update interface{}, opts ...*options.FindOneAndUpdateOptions) error {
+	return c.FindOneAndUpdateDecode(ctx, nil, dbName, collName, filter, update, opts...)
+}
+
+func (c *MongoClient) FindOneAndDeleteCtx(ctx context.Context, dbName, collName string, filter interface{}, opts ...*options.FindOneAndDeleteOptions) error {
+	return c.FindOneAndDeleteDecode(ctx, nil, dbName, collName, filter, opts...)
+}
 
-	coll := c.DbColl(dbName, collName)
-	if coll == nil {
-		return fmt.Errorf("cannot find collection:%+v,%+v", dbName, collName)
-	}
+func (c *MongoClient) UpdateOneCtx(ctx context.Context, dbName, collName string, filter interface{}, update interface{}, opts ...*options.UpdateOptions) error {
+	_, err := c.UpdateOneWithResult(ctx, dbName, collName, filter, update, opts...)
+	return err
+}
 
-	result := coll.FindOneAndUpdate(context.Background(), filter, update, opts...)
-	return result.Err()
+func (c *MongoClient) UpdateAllCtx(ctx context.Context, dbName, collName string, filter interface{}, update interface{}, opts ...*options.UpdateOptions) error {
+	_, err := c.UpdateAllWithResult(ctx, dbName, collName, filter, update, opts...)
+	return err
 }
 
-func (c *MongoClient) FindOneAndDelete(dbName, collName string, filter interface{}, opts ...*options.FindOneAndDeleteOptions) (err error) {
-	defer func(startTime int64) { c.promMonitor(startTime, "FindOneAndDelete", err) }(prom.NowMicrosecond())
+func (c *MongoClient) UpsertOneCtx(ctx context.Context, dbName, collName string, filter interface{}, update interface{}, opts ...*options.UpdateOptions) error {
+	_, err := c.UpsertOneWithResult(ctx, dbName, collName, filter, update, opts...)
+	return err
+}
+
+func (c *MongoClient) InsertOneCtx(ctx context.Context, dbName, collName string, document interface{}, opts ...*options.InsertOneOptions) (insertedID interface{}, err error) {
+	defer func(startTime int64) { c.promMonitor(ctx, startTime, "InsertOne", err) }(prom.NowMicrosecond())
+	defer func() { c.recordWrite(ctx, dbName, collName, err) }()
 
-	coll := c.DbColl(dbName, collName)
+	coll := c.collection(ctx, dbName, collName)
 	if coll == nil {
-		return fmt.Errorf("cannot find collection:%+v,%+v", dbName, collName)
+		return nil, fmt.Errorf("cannot find collection:%+v,%+v", dbName, collName)
 	}
 
-	result := coll.FindOneAndDelete(context.Background(), filter, opts...)
-	return result.Err()
+	var result *mongo.InsertOneResult
+	err = c.withRetry(ctx, "InsertOne", true, func() (err error) {
+		result, err = coll.InsertOne(ctx, document, opts...)
+		return
+	})
+	if err != nil {
+		return
+	}
+
+	return result.InsertedID, err
 }
 
-func (c *MongoClient) UpdateOne(dbName, collName string, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (err error) {
-	defer func(startTime int64) { c.promMonitor(startTime, "UpdateOne", err) }(prom.NowMicrosecond())
+func (c *MongoClient) InsertManyCtx(ctx context.Context, dbName, collName string, documents []interface{}, opts ...*options.InsertManyOptions) (result *mongo.InsertManyResult, err error) {
+	defer func(startTime int64) { c.promMonitor(ctx, startTime, "InsertMany", err) }(prom.NowMicrosecond())
+	defer func() { c.recordWrite(ctx, dbName, collName, err) }()
 
-	coll := c.DbColl(dbName, collName)
+	coll := c.collection(ctx, dbName, collName)
 	if coll == nil {
-		return fmt.Errorf("cannot find collection:%+v,%+v", dbName, collName)
+		err = fmt.Errorf("cannot find collection:%+v,%+v", dbName, collName)
+		return
 	}
 
-	_, err = coll.UpdateOne(context.Background(), filter, update, opts...)
+	err = c.withRetry(ctx, "InsertMany", true, func() (err error) {
+		result, err = coll.InsertMany(ctx, documents, opts...)
+		return
+	})
 	return
 }
 
-func (c *MongoClient) UpdateAll(dbName, collName string, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (err error) {
-	defer func(startTime int64) { c.promMonitor(startTime, "UpdateAll", err) }(prom.NowMicrosecond())
+func (c *MongoClient) DeleteOneCtx(ctx context.Context, dbName, collName string, filter interface{}, opts ...*options.DeleteOptions) error {
+	_, err := c.DeleteOneWithResult(ctx, dbName, collName, filter, opts...)
+	return err
+}
 
-	coll := c.DbColl(dbName, collName)
+func (c *MongoClient) DeleteManyCtx(ctx context.Context, dbName, collName string, filter interface{}, opts ...*options.DeleteOptions) error {
+	_, err := c.DeleteManyWithResult(ctx, dbName, collName, filter, opts...)
+	return err
+}
+
+func (c *MongoClient) CountCtx(ctx context.Context, dbName, collName string, filter interface{}, opts ...*options.CountOptions) (count int64, err error) {
+	defer func(startTime int64) { c.promMonitor(ctx, startTime, "Count", err) }(prom.NowMicrosecond())
+
+	coll := c.collection(ctx, dbName, collName)
 	if coll == nil {
-		return fmt.Errorf("cannot find collection:%+v,%+v", dbName, collName)
+		return count, fmt.Errorf("cannot find collection:%+v,%+v", dbName, collName)
 	}
 
-	_, err = coll.UpdateMany(context.Background(), filter, update)
+	err = c.withRetry(ctx, "Count", false, func() (err error) {
+		count, err = coll.CountDocuments(ctx, filter, opts...)
+		return
+	})
 	return
 }
 
-func (c *MongoClient) UpsertOne(dbName, collName string, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (err error) {
-	defer func(startTime int64) { c.promMonitor(startTime, "UpsertOne", err) }(prom.NowMicrosecond())
+func (c *MongoClient) AggregateCtx(ctx context.Context, result interface{}, dbName, collName string, pipeline interface{}, opts ...*options.AggregateOptions) (err error) {
+	defer func(startTime int64) { c.promMonitor(ctx, startTime, "Aggregate", err) }(prom.NowMicrosecond())
 
-	upsert := true
-	if len(opts) > 0 {
-		opts[0].Upsert = &upsert
-		return c.UpdateOne(dbName, collName, filter, update, opts...)
-	} else {
-		opt := options.UpdateOptions{}
-		opt.Upsert = &upsert
-		return c.UpdateOne(dbName, collName, filter, update, &opt)
+	resultv := reflect.ValueOf(result)
+	if resultv.Kind() != reflect.Ptr || resultv.Elem().Kind() != reflect.Slice {
+		return errors.New("result argument must be a slice address")
 	}
 
-}
-
-func (c *MongoClient) InsertOne(dbName, collName string, document interface{}, opts ...*options.InsertOneOptions) (insertedID interface{}, err error) {
-	defer func(startTime int64) { c.promMonitor(startTime, "InsertOne", err) }(prom.NowMicrosecond())
-
-	coll := c.DbColl(dbName, collName)
+	coll := c.collection(ctx, dbName, collName)
 	if coll == nil {
-		return nil, fmt.Errorf("cannot find collection:%+v,%+v", dbName, collName)
+		return fmt.Errorf("cannot find collection:%+v,%+v", dbName, collName)
 	}
 
-	result, err := coll.InsertOne(context.Background(), document, opts...)
+	var cursor *mongo.Cursor
+	err = c.withRetry(ctx, "Aggregate", false, func() (err error) {
+		cursor, err = coll.Aggregate(ctx, pipeline, opts...)
+		return
+	})
 	if err != nil {
 		return
 	}
 
-	return result.InsertedID, err
+	return DecodeAll(cursor, ctx, result)
 }
 
-func (c *MongoClient) InsertMany(dbName, collName string, documents []interface{}, opts ...*options.InsertManyOptions) (result *mongo.InsertManyResult, err error) {
-	defer func(startTime int64) { c.promMonitor(startTime, "InsertMany", err) }(prom.NowMicrosecond())
+func (c *MongoClient) BulkWriteCtx(ctx context.Context, dbName, collName string, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (err error) {
+	defer func(startTime int64) { c.promMonitor(ctx, startTime, "BulkWrite", err) }(prom.NowMicrosecond())
+	defer func() { c.recordWrite(ctx, dbName, collName, err) }()
 
-	coll := c.DbColl(dbName, collName)
+	coll := c.collection(ctx, dbName, collName)
 	if coll == nil {
-		err = fmt.Errorf("cannot find collection:%+v,%+v", dbName, collName)
-		return
+		return fmt.Errorf("cannot find collection:%+v,%+v", dbName, collName)
 	}
 
-	return coll.InsertMany(context.Background(), documents, opts...)
+	return c.withRetry(ctx, "BulkWrite", true, func() (err error) {
+		_, err = coll.BulkWrite(ctx, models, opts...)
+		return
+	})
+}
+
+// --------------------------------- Method with Client (Result) -----------------------------------
+
+// WriteResult 写操作的结果, UpsertedID 只有 upsert 插入了新文档时才不为空
+type WriteResult struct {
+	MatchedCount  int64
+	ModifiedCount int64
+	UpsertedCount int64
+	DeletedCount  int64
+	UpsertedID    interface{}
+}
+
+func newUpdateResult(r *mongo.UpdateResult) *WriteResult {
+	return &WriteResult{
+		MatchedCount:  r.MatchedCount,
+		ModifiedCount: r.ModifiedCount,
+		UpsertedCount: r.UpsertedCount,
+		UpsertedID:    r.UpsertedID,
+	}
 }
 
-func (c *MongoClient) DeleteOne(dbName, collName string, filter interface{}, opts ...*options.DeleteOptions) (err error) {
-	defer func(startTime int64) { c.promMonitor(startTime, "DeleteOne", err) }(prom.NowMicrosecond())
+// FindOneAndUpdateDecode 更新并把返回的文档解码到 result, 默认返回更新前的文档,
+// 需要更新后的文档时设置 options.FindOneAndUpdate().SetReturnDocument(options.After), result 为 nil 时不解码
+func (c *MongoClient) FindOneAndUpdateDecode(ctx context.Context, result interface{}, dbName, collName string, filter interface{}, update interface{}, opts ...*options.FindOneAndUpdateOptions) (err error) {
+	defer func(startTime int64) { c.promMonitor(ctx, startTime, "FindOneAndUpdate", err) }(prom.NowMicrosecond())
+	defer func() { c.recordWrite(ctx, dbName, collName, err) }()
 
-	coll := c.DbColl(dbName, collName)
+	coll := c.collection(ctx, dbName, collName)
 	if coll == nil {
 		return fmt.Errorf("cannot find collection:%+v,%+v", dbName, collName)
 	}
 
-	_, err = coll.DeleteOne(context.Background(), filter, opts...)
-	return
+	var singleResult *mongo.SingleResult
+	err = c.withRetry(ctx, "FindOneAndUpdate", true, func() error {
+		singleResult = coll.FindOneAndUpdate(ctx, filter, update, opts...)
+		return singleResult.Err()
+	})
+	if err != nil || result == nil {
+		return
+	}
+	return singleResult.Decode(result)
 }
 
-func (c *MongoClient) DeleteMany(dbName, collName string, filter interface{}, opts ...*options.DeleteOptions) (err error) {
-	defer func(startTime int64) { c.promMonitor(startTime, "DeleteMany", err) }(prom.NowMicrosecond())
+// FindOneAndDeleteDecode 删除并把被删除的文档解码到 result, result 为 nil 时不解码
+func (c *MongoClient) FindOneAndDeleteDecode(ctx context.Context, result interface{}, dbName, collName string, filter interface{}, opts ...*options.FindOneAndDeleteOptions) (err error) {
+	defer func(startTime int64) { c.promMonitor(ctx, startTime, "FindOneAndDelete", err) }(prom.NowMicrosecond())
+	defer func() { c.recordWrite(ctx, dbName, collName, err) }()
 
-	coll := c.DbColl(dbName, collName)
+	coll := c.collection(ctx, dbName, collName)
 	if coll == nil {
 		return fmt.Errorf("cannot find collection:%+v,%+v", dbName, collName)
 	}
 
-	_, err = coll.DeleteMany(context.Background(), filter, opts...)
-	return err
+	var singleResult *mongo.SingleResult
+	err = c.withRetry(ctx, "FindOneAndDelete", true, func() error {
+		singleResult = coll.FindOneAndDelete(ctx, filter, opts...)
+		return singleResult.Err()
+	})
+	if err != nil || result == nil {
+		return
+	}
+	return singleResult.Decode(result)
 }
 
-func (c *MongoClient) Count(dbName, collName string, filter interface{}, opts ...*options.CountOptions) (count int64, err error) {
-	defer func(startTime int64) { c.promMonitor(startTime, "Count", err) }(prom.NowMicrosecond())
+func (c *MongoClient) UpdateOneWithResult(ctx context.Context, dbName, collName string, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (result *WriteResult, err error) {
+	defer func(startTime int64) { c.promMonitor(ctx, startTime, "UpdateOne", err) }(prom.NowMicrosecond())
+	defer func() { c.recordWrite(ctx, dbName, collName, err) }()
 
-	coll := c.DbColl(dbName, collName)
+	coll := c.collection(ctx, dbName, collName)
 	if coll == nil {
-		return count, fmt.Errorf("cannot find collection:%+v,%+v", dbName, collName)
+		return nil, fmt.Errorf("cannot find collection:%+v,%+v", dbName, collName)
 	}
 
-	return coll.CountDocuments(context.Background(), filter, opts...)
+	var r *mongo.UpdateResult
+	err = c.withRetry(ctx, "UpdateOne", true, func() (err error) {
+		r, err = coll.UpdateOne(ctx, filter, update, opts...)
+		return
+	})
+	if err != nil {
+		return
+	}
+	return newUpdateResult(r), nil
 }
 
-func (c *MongoClient) Aggregate(result interface{}, dbName, collName string, pipeline interface{}, opts ...*options.AggregateOptions) (err error) {
-	defer func(startTime int64) { c.promMonitor(startTime, "Aggregate", err) }(prom.NowMicrosecond())
+func (c *MongoClient) UpdateAllWithResult(ctx context.Context, dbName, collName string, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (result *WriteResult, err error) {
+	defer func(startTime int64) { c.promMonitor(ctx, startTime, "UpdateAll", err) }(prom.NowMicrosecond())
+	defer func() { c.recordWrite(ctx, dbName, collName, err) }()
 
-	resultv := reflect.ValueOf(result)
-	if resultv.Kind() != reflect.Ptr || resultv.Elem().Kind() != reflect.Slice {
-		return errors.New("result argument must be a slice address")
+	coll := c.collection(ctx, dbName, collName)
+	if coll == nil {
+		return nil, fmt.Errorf("cannot find collection:%+v,%+v", dbName, collName)
 	}
 
-	coll := c.DbColl(dbName, collName)
+	var r *mongo.UpdateResult
+	err = c.withRetry(ctx, "UpdateAll", true, func() (err error) {
+		r, err = coll.UpdateMany(ctx, filter, update, opts...)
+		return
+	})
+	if err != nil {
+		return
+	}
+	return newUpdateResult(r), nil
+}
+
+func (c *MongoClient) ReplaceOneWithResult(ctx context.Context, dbName, collName string, filter interface{}, replacement interface{}, opts ...*options.ReplaceOptions) (result *WriteResult, err error) {
+	defer func(startTime int64) { c.promMonitor(ctx, startTime, "ReplaceOne", err) }(prom.NowMicrosecond())
+	defer func() { c.recordWrite(ctx, dbName, collName, err) }()
+
+	coll := c.collection(ctx, dbName, collName)
 	if coll == nil {
-		return fmt.Errorf("cannot find collection:%+v,%+v", dbName, collName)
+		return nil, fmt.Errorf("cannot find collection:%+v,%+v", dbName, collName)
 	}
 
-	ctx := context.Background()
-	cursor, err := coll.Aggregate(ctx, pipeline, opts...)
+	var r *mongo.UpdateResult
+	err = c.withRetry(ctx, "ReplaceOne", true, func() (err error) {
+		r, err = coll.ReplaceOne(ctx, filter, replacement, opts...)
+		return
+	})
 	if err != nil {
 		return
 	}
+	return newUpdateResult(r), nil
+}
 
-	return DecodeAll(cursor, ctx, result)
+func (c *MongoClient) UpsertOneWithResult(ctx context.Context, dbName, collName string, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (result *WriteResult, err error) {
+	defer func(startTime int64) { c.promMonitor(ctx, startTime, "UpsertOne", err) }(prom.NowMicrosecond())
+
+	upsert := true
+	if len(opts) > 0 {
+		opts[0].Upsert = &upsert
+		return c.UpdateOneWithResult(ctx, dbName, collName, filter, update, opts...)
+	} else {
+		opt := options.UpdateOptions{}
+		opt.Upsert = &upsert
+		return c.UpdateOneWithResult(ctx, dbName, collName, filter, update, &opt)
+	}
 }
 
-func (c *MongoClient) BulkWrite(dbName, collName string, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (err error) {
-	defer func(startTime int64) { c.promMonitor(startTime, "BulkWrite", err) }(prom.NowMicrosecond())
+func (c *MongoClient) DeleteOneWithResult(ctx context.Context, dbName, collName string, filter interface{}, opts ...*options.DeleteOptions) (result *WriteResult, err error) {
+	defer func(startTime int64) { c.promMonitor(ctx, startTime, "DeleteOne", err) }(prom.NowMicrosecond())
+	defer func() { c.recordWrite(ctx, dbName, collName, err) }()
 
-	coll := c.DbColl(dbName, collName)
+	coll := c.collection(ctx, dbName, collName)
 	if coll == nil {
-		return fmt.Errorf("cannot find collection:%+v,%+v", dbName, collName)
+		return nil, fmt.Errorf("cannot find collection:%+v,%+v", dbName, collName)
 	}
 
-	_, err = coll.BulkWrite(context.Background(), models, opts...)
-	return err
+	var r *mongo.DeleteResult
+	err = c.withRetry(ctx, "DeleteOne", true, func() (err error) {
+		r, err = coll.DeleteOne(ctx, filter, opts...)
+		return
+	})
+	if err != nil {
+		return
+	}
+	return &WriteResult{DeletedCount: r.DeletedCount}, nil
+}
+
+func (c *MongoClient) DeleteManyWithResult(ctx context.Context, dbName, collName string, filter interface{}, opts ...*options.DeleteOptions) (result *WriteResult, err error) {
+	defer func(startTime int64) { c.promMonitor(ctx, startTime, "DeleteMany", err) }(prom.NowMicrosecond())
+	defer func() { c.recordWrite(ctx, dbName, collName, err) }()
+
+	coll := c.collection(ctx, dbName, collName)
+	if coll == nil {
+		return nil, fmt.Errorf("cannot find collection:%+v,%+v", dbName, collName)
+	}
+
+	var r *mongo.DeleteResult
+	err = c.withRetry(ctx, "DeleteMany", true, func() (err error) {
+		r, err = coll.DeleteMany(ctx, filter, opts...)
+		return
+	})
+	if err != nil {
+		return
+	}
+	return &WriteResult{DeletedCount: r.DeletedCount}, nil
 }
 
 // --------------------------------- Global Mongo Cursor -------------------------------------------
@@ -671,12 +1037,8 @@ func makeIndex(key string, ascending bool, unique bool) mongo.IndexModel {
 
 // --------------------------------- Prom Monitor----------------------------------------
 
-func (c *MongoClient) promMonitor(start int64, method string, err error) {
+func (c *MongoClient) promMonitor(ctx context.Context, start int64, method string, err error) {
 	if c.prom {
-		status := "Success"
-		if err != nil {
-			status = "Fail"
-		}
-		prom.SetMongoMetrics(float64(prom.NowMicrosecond()-start), method, status)
+		prom.SetMongoMetrics(float64(prom.NowMicrosecond()-start), method, prom.Status(ctx, err))
 	}
 }
@@ -1,6 +1,7 @@
 package zmgo
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"go.mongodb.org/mongo-driver/bson"
@@ -17,6 +18,8 @@ const (
 	caFilePath = "/home/ec2-user/rds-combined-ca-bundle.pem"
 	DB_NAME    = "test" //project_id
 	COL_NAME   = "user"
+
+	resumeTokenColl = "zmgo_resume_tokens"
 )
 
 var (
@@ -56,6 +59,13 @@ func TestMongo(t *testing.T) {
 	t.Run("TestCount", TestCount)
 	t.Run("TestBulkWrite", TestBulkWrite)
 	t.Run("TestNewMyCursorAndAll", TestNewMyCursorAndAll)
+	t.Run("TestFindOneCtxCanceled", TestFindOneCtxCanceled)
+	t.Run("TestFindIter", TestFindIter)
+	t.Run("TestWriteWithResult", TestWriteWithResult)
+	t.Run("TestMigrate", TestMigrate)
+	t.Run("TestRepository", TestRepository)
+	t.Run("TestCausalSession", TestCausalSession)
+	t.Run("TestWatchResume", TestWatchResume)
 }
 
 func TestConnect(t *testing.T) {
@@ -627,6 +637,247 @@ func TestNewMyCursorAndAll(t *testing.T) {
 	t.Log("TestNewMyCursorAndAll Success")
 }
 
+func TestFindOneCtxCanceled(t *testing.T) {
+	defer func() {
+		deleteTestData(t)
+		t.Log("==================TestFindOneCtxCanceled end====================")
+	}()
+	t.Log("==================TestFindOneCtxCanceled begin==================")
+
+	initMongoClient(t)
+	insertTestData(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var result *User
+	err := FindOneCtx(ctx, &result, DB_NAME, COL_NAME, bson.M{"_id": 1})
+	if err == nil {
+		t.Fatal("FindOneCtx with canceled context should fail")
+	}
+	t.Logf("FindOneCtx canceled err: %v", err)
+
+	err = FindOneCtx(context.Background(), &result, DB_NAME, COL_NAME, bson.M{"_id": 1})
+	if err != nil || !result.equals(document[1]) {
+		t.Fatal("TestFindOneCtxCanceled Fail")
+	}
+	t.Log("TestFindOneCtxCanceled Success")
+}
+
+func TestFindIter(t *testing.T) {
+	defer func() {
+		deleteTestData(t)
+		t.Log("==================TestFindIter end====================")
+	}()
+	t.Log("==================TestFindIter begin==================")
+
+	initMongoClient(t)
+	insertTestData(t)
+	ctx := context.Background()
+
+	t.Log("[ForEach decode one by one]")
+	iter, err := FindIter(ctx, DB_NAME, COL_NAME, bson.M{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := 0
+	err = iter.ForEach(func(it *Iter) error {
+		var u User
+		if err := it.Decode(&u); err != nil {
+			return err
+		}
+		if !u.equals(document[i]) {
+			return fmt.Errorf("unexpected document: %+v", u)
+		}
+		i++
+		return nil
+	})
+	if err != nil || i != len(document) {
+		t.Fatalf("ForEach Fail, count: %d, err: %v", i, err)
+	}
+
+	t.Log("[ForEachBatch with batch size 2]")
+	iter, err = FindIter(ctx, DB_NAME, COL_NAME, bson.M{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	batches := make([]int, 0)
+	result := make([]*User, 0)
+	err = iter.ForEachBatch(&result, 2, func() error {
+		batches = append(batches, len(result))
+		return nil
+	})
+	if err != nil || len(batches) != 3 || batches[0] != 2 || batches[2] != 1 {
+		t.Fatalf("ForEachBatch Fail, batches: %v, err: %v", batches, err)
+	}
+	t.Log("TestFindIter Success")
+}
+
+func TestWriteWithResult(t *testing.T) {
+	defer func() {
+		deleteTestData(t)
+		t.Log("==================TestWriteWithResult end====================")
+	}()
+	t.Log("==================TestWriteWithResult begin==================")
+
+	initMongoClient(t)
+	insertTestData(t)
+	ctx := context.Background()
+
+	t.Log("[UpsertOneWithResult insert new document _id:520]")
+	result, err := UpsertOneWithResult(ctx, DB_NAME, COL_NAME, bson.M{"_id": 520}, bson.M{"$set": bson.M{"name": "bigPig"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.UpsertedCount != 1 || result.UpsertedID == nil {
+		t.Fatalf("UpsertOneWithResult Fail: %+v", result)
+	}
+
+	t.Log("[UpdateAllWithResult pig -> bigPig]")
+	result, err = UpdateAllWithResult(ctx, DB_NAME, COL_NAME, bson.M{"name": "pig"}, bson.M{"$set": bson.M{"name": "bigPig"}})
+	if err != nil || result.MatchedCount != 2 || result.ModifiedCount != 2 {
+		t.Fatalf("UpdateAllWithResult Fail: %+v, err: %v", result, err)
+	}
+
+	t.Log("[FindOneAndDeleteDecode _id:520]")
+	var deleted User
+	err = FindOneAndDeleteDecode(ctx, &deleted, DB_NAME, COL_NAME, bson.M{"_id": 520})
+	if err != nil || deleted.ID != 520 || deleted.Name != "bigPig" {
+		t.Fatalf("FindOneAndDeleteDecode Fail: %+v, err: %v", deleted, err)
+	}
+
+	t.Log("[DeleteManyWithResult bigPig]")
+	result, err = DeleteManyWithResult(ctx, DB_NAME, COL_NAME, bson.M{"name": "bigPig"})
+	if err != nil || result.DeletedCount != 2 {
+		t.Fatalf("DeleteManyWithResult Fail: %+v, err: %v", result, err)
+	}
+	t.Log("TestWriteWithResult Success")
+}
+
+func TestMigrate(t *testing.T) {
+	defer func() {
+		resetMigrations()
+		deleteTestData(t)
+		_ = DeleteMany(DB_NAME, MigrationCollection, bson.M{})
+		t.Log("==================TestMigrate end====================")
+	}()
+	t.Log("==================TestMigrate begin==================")
+	resetMigrations()
+
+	initMongoClient(t)
+	deleteTestData(t)
+	ctx := context.Background()
+	c, err := GetClient(DB_NAME)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	RegisterMigration(Migration{
+		Version: 1,
+		Name:    "insert pig",
+		Up: func(ctx context.Context, c *MongoClient, dbName string) error {
+			_, err := c.InsertOneCtx(ctx, dbName, COL_NAME, document[0])
+			return err
+		},
+		Down: func(ctx context.Context, c *MongoClient, dbName string) error {
+			return c.DeleteOneCtx(ctx, dbName, COL_NAME, bson.M{"_id": 0})
+		},
+	})
+
+	applied, err := c.Migrate(ctx, DB_NAME)
+	if err != nil || len(applied) != 1 {
+		t.Fatalf("Migrate Fail, applied: %+v, err: %v", applied, err)
+	}
+	t.Log("[migrate again, nothing should be applied]")
+	applied, err = c.Migrate(ctx, DB_NAME)
+	if err != nil || len(applied) != 0 {
+		t.Fatalf("Migrate again Fail, applied: %+v, err: %v", applied, err)
+	}
+
+	t.Log("[migrate down to version 0]")
+	reverted, err := c.MigrateDown(ctx, DB_NAME, 0)
+	if err != nil || len(reverted) != 1 {
+		t.Fatalf("MigrateDown Fail, reverted: %+v, err: %v", reverted, err)
+	}
+	count, err := Count(DB_NAME, COL_NAME, bson.M{})
+	if err != nil || count != 0 {
+		t.Fatalf("MigrateDown Fail, count: %d, err: %v", count, err)
+	}
+
+	t.Log("[lock is renewed while a migration runs longer than the ttl]")
+	ttl := MigrationLockTTL
+	MigrationLockTTL = 300 * time.Millisecond
+	defer func() { MigrationLockTTL = ttl }()
+	RegisterMigration(Migration{
+		Version: 2,
+		Name:    "slow",
+		Up: func(ctx context.Context, c *MongoClient, dbName string) error {
+			time.Sleep(2 * MigrationLockTTL)
+			if _, err := c.acquireMigrationLock(ctx, dbName); err != ErrMigrationLocked {
+				return fmt.Errorf("lock should still be held, got %v", err)
+			}
+			return nil
+		},
+	})
+	applied, err = c.Migrate(ctx, DB_NAME)
+	if err != nil || len(applied) != 2 {
+		t.Fatalf("Migrate slow Fail, applied: %+v, err: %v", applied, err)
+	}
+	t.Log("TestMigrate Success")
+}
+
+// resetMigrations 清空注册的迁移, 测试可以重复执行
+func resetMigrations() {
+	migrationsMu.Lock()
+	defer migrationsMu.Unlock()
+	migrations = make(map[int64]*Migration)
+}
+
+//TestWithTransaction 事务需要副本集, 单机mongo下请单独跳过
+func TestWithTransaction(t *testing.T) {
+	defer func() {
+		deleteTestData(t)
+		t.Log("==================TestWithTransaction end====================")
+	}()
+	t.Log("==================TestWithTransaction begin==================")
+
+	initMongoClient(t)
+	deleteTestData(t)
+	ctx := context.Background()
+
+	t.Log("[callback fail, insert should be rolled back]")
+	err := WithTransaction(ctx, DB_NAME, func(sessCtx mongo.SessionContext) error {
+		if _, err := InsertOneCtx(sessCtx, DB_NAME, COL_NAME, document[0]); err != nil {
+			return err
+		}
+		return fmt.Errorf("rollback")
+	})
+	if err == nil {
+		t.Fatal("WithTransaction should return callback error")
+	}
+	count, err := Count(DB_NAME, COL_NAME, bson.M{})
+	if err != nil || count != 0 {
+		t.Fatalf("WithTransaction rollback Fail, count: %d, err: %v", count, err)
+	}
+
+	t.Log("[callback success, both inserts should be committed]")
+	err = WithTransaction(ctx, DB_NAME, func(sessCtx mongo.SessionContext) error {
+		if _, err := InsertOneCtx(sessCtx, DB_NAME, COL_NAME, document[0]); err != nil {
+			return err
+		}
+		_, err := InsertOneCtx(sessCtx, DB_NAME, COL_NAME, document[1])
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, err = Count(DB_NAME, COL_NAME, bson.M{})
+	if err != nil || count != 2 {
+		t.Fatalf("WithTransaction commit Fail, count: %d, err: %v", count, err)
+	}
+	t.Log("TestWithTransaction Success")
+}
+
 //------------------- Function for TestUnit -------------------
 
 //initMongoClient 初始化mongo客户端并传递FindClient方法
@@ -665,3 +916,165 @@ func (U *User) equals(I interface{}) bool {
 	u := I.(User)
 	return U.ID == u.ID && U.Name == u.Name
 }
+
+func TestRepository(t *testing.T) {
+	defer func() {
+		deleteTestData(t)
+		t.Log("==================TestRepository end====================")
+	}()
+	t.Log("==================TestRepository begin==================")
+
+	initMongoClient(t)
+	insertTestData(t)
+	ctx := context.Background()
+	users := NewRepository[User](nil, DB_NAME, COL_NAME)
+
+	t.Log("[Insert _id:521 and Get]")
+	if _, err := users.Insert(ctx, &User{ID: 521, Name: "cat", UpdatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	user, err := users.Get(ctx, 521)
+	if err != nil || user.Name != "cat" {
+		t.Fatalf("Get Fail: %+v, err: %v", user, err)
+	}
+
+	t.Log("[Update _id:521 cat -> bigCat]")
+	result, err := users.Update(ctx, 521, bson.M{"$set": bson.M{"name": "bigCat"}})
+	if err != nil || result.ModifiedCount != 1 {
+		t.Fatalf("Update Fail: %+v, err: %v", result, err)
+	}
+
+	t.Log("[Find pig]")
+	pigs, err := users.Find(ctx, bson.M{"name": "pig"})
+	if err != nil || len(pigs) != 2 {
+		t.Fatalf("Find Fail: %+v, err: %v", pigs, err)
+	}
+
+	t.Log("[Delete _id:521 and Get returns ErrNoDocuments]")
+	if deleted, err := users.Delete(ctx, 521); err != nil || !deleted {
+		t.Fatalf("Delete Fail: %v, err: %v", deleted, err)
+	}
+	if _, err = users.Get(ctx, 521); err != mongo.ErrNoDocuments {
+		t.Fatalf("Get after Delete should return ErrNoDocuments, got %v", err)
+	}
+	if exists, err := users.Exists(ctx, bson.M{"_id": 521}); err != nil || exists {
+		t.Fatalf("Exists Fail: %v, err: %v", exists, err)
+	}
+	t.Log("TestRepository Success")
+}
+
+func TestCausalSession(t *testing.T) {
+	defer func() {
+		deleteTestData(t)
+		t.Log("==================TestCausalSession end====================")
+	}()
+	t.Log("==================TestCausalSession begin==================")
+
+	initMongoClient(t)
+	ctx := context.Background()
+
+	t.Log("[write in one session and pass the token on]")
+	sess, err := StartCausalSession(DB_NAME, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = InsertOneCtx(sess.Context(ctx), DB_NAME, COL_NAME, User{ID: 530, Name: "owl", UpdatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	token, err := sess.Token()
+	sess.End(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Log("[resumed session reads its own write]")
+	resumed, err := StartCausalSession(DB_NAME, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resumed.End(ctx)
+	var user User
+	if err = FindOneCtx(resumed.Context(ctx), &user, DB_NAME, COL_NAME, bson.M{"_id": 530}); err != nil || user.Name != "owl" {
+		t.Fatalf("read after resume Fail: %+v, err: %v", user, err)
+	}
+	t.Log("TestCausalSession Success")
+}
+
+//TestWatchResume change stream 需要副本集
+func TestWatchResume(t *testing.T) {
+	defer func() {
+		deleteTestData(t)
+		_ = DeleteMany(DB_NAME, resumeTokenColl, bson.M{})
+		t.Log("==================TestWatchResume end====================")
+	}()
+	t.Log("==================TestWatchResume begin==================")
+
+	initMongoClient(t)
+	deleteTestData(t)
+	ctx := context.Background()
+	c, err := GetClient(DB_NAME)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Log("[token store round trip]")
+	store := NewMongoTokenStore(c, DB_NAME, resumeTokenColl)
+	if token, err := store.Load(ctx, "missing"); err != nil || token != nil {
+		t.Fatalf("Load missing token Fail: %v, err: %v", token, err)
+	}
+	saved, _ := bson.Marshal(bson.M{"_data": "8263F1"})
+	if err = store.Save(ctx, "saved", saved); err != nil {
+		t.Fatal(err)
+	}
+	if token, err := store.Load(ctx, "saved"); err != nil || !bytes.Equal(token, saved) {
+		t.Fatalf("Load saved token Fail: %v, err: %v", token, err)
+	}
+
+	t.Log("[subscription resumes after the last handled event]")
+	events := make(chan *ChangeEvent, 10)
+	handler := func(event *ChangeEvent) error {
+		events <- event
+		return nil
+	}
+	nextUser := func() User {
+		var user User
+		select {
+		case event := <-events:
+			if err := event.DecodeFullDocument(&user); err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("timeout waiting for change event")
+		}
+		return user
+	}
+
+	opts := &WatchOptions{TokenStore: store, TokenKey: "resume"}
+	sub, err := Watch(DB_NAME, COL_NAME, nil, handler, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = InsertOneCtx(ctx, DB_NAME, COL_NAME, document[0]); err != nil {
+		t.Fatal(err)
+	}
+	if user := nextUser(); user.ID != 0 {
+		t.Fatalf("first event Fail: %+v", user)
+	}
+	if err = sub.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 订阅停止期间的写入在重新订阅后收到
+	if _, err = InsertOneCtx(ctx, DB_NAME, COL_NAME, document[1]); err != nil {
+		t.Fatal(err)
+	}
+	sub, err = Watch(DB_NAME, COL_NAME, nil, handler, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	if user := nextUser(); user.ID != 1 {
+		t.Fatalf("resumed event Fail: %+v", user)
+	}
+	t.Log("TestWatchResume Success")
+}
@@ -0,0 +1,356 @@
+package zmgo
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"github.com/QuRuijie/zenDB/prom"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"hash"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	pageForward  = "n"
+	pageBackward = "p"
+)
+
+var (
+	ErrInvalidPageToken      = errors.New("invalid page token")
+	ErrPageTokenSecretNotSet = errors.New("page token secret not set, call SetPageTokenSecret first")
+
+	pageTokenSecret   []byte
+	pageTokenSecretMu sync.RWMutex
+)
+
+// SetPageTokenSecret 设置分页 token 的签名密钥, 调用 Paginate 前必须设置,
+// 多实例部署时所有实例必须设置相同的密钥, 否则 token 换一个实例或重启后就失效了
+func SetPageTokenSecret(secret []byte) {
+	pageTokenSecretMu.Lock()
+	defer pageTokenSecretMu.Unlock()
+	pageTokenSecret = append([]byte(nil), secret...)
+}
+
+// SortKey 分页排序字段, Field 支持 "a.b" 形式的嵌套字段, 排序字段在文档中必须存在, 设置了 Projection 时必须包含排序字段
+type SortKey struct {
+	Field string
+	Desc  bool
+}
+
+type PageRequest struct {
+	Filter interface{}
+	// Sort 排序字段, 最后没有 _id 时自动追加 _id 升序保证排序唯一
+	Sort       []SortKey
+	PageSize   int64
+	Projection interface{}
+	// Token 上一页返回的 NextToken 或 PrevToken, 为空时取第一页
+	Token string
+}
+
+type Page struct {
+	NextToken string
+	PrevToken string
+	HasNext   bool
+	HasPrev   bool
+}
+
+type pageToken struct {
+	Direction string          `bson:"d"`
+	Values    []bson.RawValue `bson:"v"`
+	Query     []byte          `bson:"q"`
+}
+
+// --------------------------------- Method without Client ---------------------------------------
+
+func Paginate(ctx context.Context, result interface{}, dbName, collName string, req *PageRequest) (*Page, error) {
+	c, err := GetClient(dbName)
+	if err != nil {
+		return nil, err
+	}
+	return c.Paginate(ctx, result, dbName, collName, req)
+}
+
+// --------------------------------- Method with Client --------------------------------------------
+
+// Paginate 基于排序字段值(keyset)分页, 翻页代价与页数无关, result 必须是 slice 地址
+func (c *MongoClient) Paginate(ctx context.Context, result interface{}, dbName, collName string, req *PageRequest) (page *Page, err error) {
+	defer func(startTime int64) { c.promMonitor(ctx, startTime, "Paginate", err) }(prom.NowMicrosecond())
+
+	resultv := reflect.ValueOf(result)
+	if resultv.Kind() != reflect.Ptr || resultv.Elem().Kind() != reflect.Slice {
+		return nil, errors.New("result argument must be a slice address")
+	}
+	if req.PageSize <= 0 {
+		return nil, errors.New("page size must be positive")
+	}
+	if !hasPageTokenSecret() {
+		return nil, ErrPageTokenSecretNotSet
+	}
+
+	coll := c.collection(ctx, dbName, collName)
+	if coll == nil {
+		return nil, fmt.Errorf("cannot find collection:%+v,%+v", dbName, collName)
+	}
+
+	sortKeys := withIDTieBreaker(req.Sort)
+	direction := pageForward
+	filter := req.Filter
+	if filter == nil {
+		filter = bson.M{}
+	}
+	queryHash, err := pageQueryHash(dbName, collName, filter, req.Projection, sortKeys)
+	if err != nil {
+		return nil, err
+	}
+	if req.Token != "" {
+		token, err := decodePageToken(req.Token)
+		if err != nil {
+			return nil, err
+		}
+		if !hmac.Equal(token.Query, queryHash) || len(token.Values) != len(sortKeys) {
+			return nil, ErrInvalidPageToken
+		}
+		direction = token.Direction
+		filter = bson.M{"$and": bson.A{filter, keysetFilter(sortKeys, token.Values, direction)}}
+	}
+
+	opts := options.Find().
+		SetSort(pageSortDoc(sortKeys, direction == pageBackward)).
+		SetLimit(req.PageSize + 1)
+	if req.Projection != nil {
+		opts.SetProjection(req.Projection)
+	}
+
+	var cursor *mongo.Cursor
+	err = c.withRetry(ctx, "Paginate", false, func() (err error) {
+		cursor, err = coll.Find(ctx, filter, opts)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	docs := make([]bson.Raw, 0, req.PageSize+1)
+	for cursor.Next(ctx) {
+		docs = append(docs, append(bson.Raw(nil), cursor.Current...))
+	}
+	if err = cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	hasMore := int64(len(docs)) > req.PageSize
+	if hasMore {
+		docs = docs[:req.PageSize]
+	}
+
+	page = &Page{}
+	if direction == pageBackward {
+		for i, j := 0, len(docs)-1; i < j; i, j = i+1, j-1 {
+			docs[i], docs[j] = docs[j], docs[i]
+		}
+		page.HasPrev = hasMore
+		page.HasNext = true
+	} else {
+		page.HasPrev = req.Token != ""
+		page.HasNext = hasMore
+	}
+
+	slicev := resultv.Elem().Slice(0, 0)
+	elemt := slicev.Type().Elem()
+	for _, doc := range docs {
+		elemp := reflect.New(elemt)
+		if err = bson.Unmarshal(doc, elemp.Interface()); err != nil {
+			return nil, err
+		}
+		slicev = reflect.Append(slicev, elemp.Elem())
+	}
+	resultv.Elem().Set(slicev)
+
+	if len(docs) == 0 {
+		return page, nil
+	}
+	if page.HasNext {
+		if page.NextToken, err = encodePageToken(pageForward, docs[len(docs)-1], sortKeys, queryHash); err != nil {
+			return nil, err
+		}
+	}
+	if page.HasPrev {
+		if page.PrevToken, err = encodePageToken(pageBackward, docs[0], sortKeys, queryHash); err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
+
+// --------------------------------- Page Token --------------------------------------------
+
+func withIDTieBreaker(sortKeys []SortKey) []SortKey {
+	keys := make([]SortKey, 0, len(sortKeys)+1)
+	for _, k := range sortKeys {
+		keys = append(keys, k)
+		if k.Field == "_id" {
+			return keys
+		}
+	}
+	return append(keys, SortKey{Field: "_id"})
+}
+
+func pageSortDoc(sortKeys []SortKey, reverse bool) bson.D {
+	sort := make(bson.D, 0, len(sortKeys))
+	for _, k := range sortKeys {
+		order := 1
+		if k.Desc != reverse {
+			order = -1
+		}
+		sort = append(sort, bson.E{Key: k.Field, Value: order})
+	}
+	return sort
+}
+
+// keysetFilter 生成 "排在 values 之后(或之前)" 的条件:
+// {$or: [{k1: {$gt: v1}}, {k1: v1, k2: {$gt: v2}}, ...]}
+func keysetFilter(sortKeys []SortKey, values []bson.RawValue, direction string) bson.M {
+	or := make(bson.A, 0, len(sortKeys))
+	for i, k := range sortKeys {
+		cond := bson.D{}
+		for j := 0; j < i; j++ {
+			cond = append(cond, bson.E{Key: sortKeys[j].Field, Value: values[j]})
+		}
+		op := "$gt"
+		if k.Desc != (direction == pageBackward) {
+			op = "$lt"
+		}
+		cond = append(cond, bson.E{Key: k.Field, Value: bson.M{op: values[i]}})
+		or = append(or, cond)
+	}
+	return bson.M{"$or": or}
+}
+
+// pageQueryHash token 只能用于相同的查询: 集合, 过滤条件, 投影(决定 token 中的排序字段值)和排序字段
+func pageQueryHash(dbName, collName string, filter, projection interface{}, sortKeys []SortKey) ([]byte, error) {
+	h := sha256.New()
+	h.Write([]byte(dbName + "." + collName))
+	for _, doc := range []interface{}{filter, projection} {
+		h.Write([]byte{'|'})
+		if doc == nil {
+			continue
+		}
+		raw, err := bson.Marshal(doc)
+		if err != nil {
+			return nil, errors.Wrap(err, "marshal page query")
+		}
+		hashCanonical(h, bson.RawValue{Type: bsontype.EmbeddedDocument, Value: raw})
+	}
+	for _, k := range sortKeys {
+		h.Write([]byte(fmt.Sprintf("|%s:%t", k.Field, k.Desc)))
+	}
+	return h.Sum(nil), nil
+}
+
+// hashCanonical 文档的字段按名称排序后写入 h, 字段顺序不固定的 bson.M 也能得到相同的结果
+func hashCanonical(h hash.Hash, v bson.RawValue) {
+	switch v.Type {
+	case bsontype.EmbeddedDocument:
+		elems, _ := v.Document().Elements()
+		sort.Slice(elems, func(i, j int) bool { return elems[i].Key() < elems[j].Key() })
+		h.Write([]byte{'{'})
+		for _, e := range elems {
+			h.Write([]byte(e.Key()))
+			h.Write([]byte{0})
+			hashCanonical(h, e.Value())
+		}
+		h.Write([]byte{'}'})
+	case bsontype.Array:
+		values, _ := v.Array().Values()
+		h.Write([]byte{'['})
+		for _, value := range values {
+			hashCanonical(h, value)
+		}
+		h.Write([]byte{']'})
+	default:
+		h.Write([]byte{byte(v.Type)})
+		h.Write(v.Value)
+	}
+}
+
+func encodePageToken(direction string, doc bson.Raw, sortKeys []SortKey, queryHash []byte) (string, error) {
+	values := make([]bson.RawValue, 0, len(sortKeys))
+	for _, k := range sortKeys {
+		// 缺少的字段按 null 写入 token 会让下一页的条件错误, 跳过或重复文档
+		v, err := doc.LookupErr(strings.Split(k.Field, ".")...)
+		if err != nil {
+			return "", errors.Errorf("page sort field %s not found in document, it must exist and be included in the projection", k.Field)
+		}
+		values = append(values, v)
+	}
+
+	payload, err := bson.Marshal(pageToken{Direction: direction, Values: values, Query: queryHash})
+	if err != nil {
+		return "", err
+	}
+	sig, err := signPageToken(payload)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func decodePageToken(s string) (*pageToken, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) != 2 {
+		return nil, ErrInvalidPageToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidPageToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidPageToken
+	}
+	expected, err := signPageToken(payload)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(sig, expected) {
+		return nil, ErrInvalidPageToken
+	}
+
+	token := &pageToken{}
+	if err = bson.Unmarshal(payload, token); err != nil {
+		return nil, ErrInvalidPageToken
+	}
+	if token.Direction != pageForward && token.Direction != pageBackward {
+		return nil, ErrInvalidPageToken
+	}
+	return token, nil
+}
+
+func hasPageTokenSecret() bool {
+	pageTokenSecretMu.RLock()
+	defer pageTokenSecretMu.RUnlock()
+	return len(pageTokenSecret) > 0
+}
+
+func signPageToken(payload []byte) ([]byte, error) {
+	pageTokenSecretMu.RLock()
+	secret := pageTokenSecret
+	pageTokenSecretMu.RUnlock()
+	if len(secret) == 0 {
+		return nil, ErrPageTokenSecretNotSet
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return mac.Sum(nil), nil
+}
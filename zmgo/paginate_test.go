@@ -0,0 +1,71 @@
+package zmgo
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"testing"
+)
+
+func TestPageToken(t *testing.T) {
+	defer func() {
+		t.Log("==================TestPageToken end====================")
+	}()
+	t.Log("==================TestPageToken begin==================")
+
+	t.Log("[page token secret is required]")
+	doc, err := bson.Marshal(User{ID: 3, Name: "hen"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetPageTokenSecret(nil)
+	if _, err = encodePageToken(pageForward, doc, withIDTieBreaker(nil), nil); err != ErrPageTokenSecretNotSet {
+		t.Fatalf("token signed without secret: %v", err)
+	}
+	SetPageTokenSecret([]byte("zmgo-test-secret"))
+
+	sortKeys := withIDTieBreaker([]SortKey{{Field: "name", Desc: true}})
+	if len(sortKeys) != 2 || sortKeys[1].Field != "_id" {
+		t.Fatalf("withIDTieBreaker Fail: %+v", sortKeys)
+	}
+
+	hash, err := pageQueryHash(DB_NAME, COL_NAME, bson.M{"age": bson.M{"$gt": 1}, "name": "hen"}, nil, sortKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := encodePageToken(pageForward, doc, sortKeys, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := decodePageToken(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Direction != pageForward || token.Values[0].StringValue() != "hen" || token.Values[1].AsInt64() != 3 {
+		t.Fatalf("decodePageToken Fail: %+v", token)
+	}
+
+	t.Log("[sort field missing from document]")
+	projectedDoc, _ := bson.Marshal(bson.M{"_id": 3})
+	if _, err = encodePageToken(pageForward, projectedDoc, sortKeys, hash); err == nil {
+		t.Fatal("token encoded without sort field")
+	}
+
+	t.Log("[query hash covers filter and projection]")
+	same, _ := pageQueryHash(DB_NAME, COL_NAME, bson.D{{Key: "name", Value: "hen"}, {Key: "age", Value: bson.M{"$gt": 1}}}, nil, sortKeys)
+	if string(same) != string(hash) {
+		t.Fatal("same filter with different field order should have the same hash")
+	}
+	other, _ := pageQueryHash(DB_NAME, COL_NAME, bson.M{"age": bson.M{"$gt": 2}, "name": "hen"}, nil, sortKeys)
+	projected, _ := pageQueryHash(DB_NAME, COL_NAME, bson.M{"age": bson.M{"$gt": 1}, "name": "hen"}, bson.M{"name": 1}, sortKeys)
+	if string(other) == string(hash) || string(projected) == string(hash) {
+		t.Fatal("different filter or projection should have a different hash")
+	}
+
+	t.Log("[tampered token must be rejected]")
+	tampered := []byte(s)
+	tampered[len(tampered)/4] ^= 1
+	if _, err = decodePageToken(string(tampered)); err != ErrInvalidPageToken {
+		t.Fatalf("tampered token accepted: %v", err)
+	}
+	t.Log("TestPageToken Success")
+}
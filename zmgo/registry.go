@@ -0,0 +1,489 @@
+package zmgo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/QuRuijie/zenDB/lifecycle"
+	"github.com/Zentertain/zenlog"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultClientKey 配置中该 key 对应 CommonClient, 没有单独配置的项目都使用它
+	DefaultClientKey = "default"
+
+	// RegistryRetireDelay 客户端被替换后等待该时间再开始关闭, 让替换前通过 GetClient 拿到它的调用有机会开始执行
+	RegistryRetireDelay = 5 * time.Second
+	// RegistryDrainTimeout 被替换的客户端等待进行中操作结束的最长时间
+	RegistryDrainTimeout = 30 * time.Second
+)
+
+// ClientConfig 项目 -> mongo uri, 和 Init 的参数格式相同, DefaultClientKey 为 CommonClient
+type ClientConfig map[string]string
+
+// ClientErrors 按客户端 key 记录的错误
+type ClientErrors map[string]error
+
+func (e ClientErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for key, err := range e {
+		msgs = append(msgs, fmt.Sprintf("%s: %v", key, err))
+	}
+	sort.Strings(msgs)
+	return "zmgo: " + strings.Join(msgs, "; ")
+}
+
+var ErrClientNotFound = errors.New("mongo client not found")
+
+// FallbackPolicy Registry 找不到项目的客户端时的处理
+type FallbackPolicy int
+
+const (
+	// FallbackDefault 使用 DefaultClientKey 的客户端, 和 ClientMap/CommonClient 的行为一致
+	FallbackDefault FallbackPolicy = iota
+	// FallbackNone 返回 ErrClientNotFound
+	FallbackNone
+)
+
+// ClientInfo 客户端及其所属项目的信息
+type ClientInfo struct {
+	Key    string
+	URI    string
+	Client *MongoClient
+	// Metadata 项目自定义信息, 如所属区域, 负责人, 客户端替换后保留
+	Metadata map[string]string
+	AddedAt  time.Time
+}
+
+type registryEntry struct {
+	uri      string
+	client   *MongoClient
+	metadata map[string]string
+	addedAt  time.Time
+}
+
+func (e *registryEntry) info(key string) ClientInfo {
+	md := make(map[string]string, len(e.metadata))
+	for k, v := range e.metadata {
+		md[k] = v
+	}
+	return ClientInfo{Key: key, URI: e.uri, Client: e.client, Metadata: md, AddedAt: e.addedAt}
+}
+
+// Registry 并发安全的项目客户端表, 可以在运行时按配置增加, 替换和下线客户端.
+// 新客户端连接并 ping 成功后才会替换旧客户端, 旧客户端在后台等待进行中的操作结束后关闭
+type Registry struct {
+	// NewClient 按 uri 创建客户端, 默认使用 NewMongoClientWithProm
+	NewClient    func(uri string) (*MongoClient, error)
+	Fallback     FallbackPolicy
+	RetireDelay  time.Duration
+	DrainTimeout time.Duration
+
+	applyMu  sync.Mutex
+	mu       sync.RWMutex
+	entries  map[string]*registryEntry
+	onChange func(entries map[string]*registryEntry)
+	life     *lifecycle.Tracker
+	retiring sync.WaitGroup
+	closing  chan struct{}
+	closeMu  sync.Once
+}
+
+func NewRegistry(prom bool) *Registry {
+	return &Registry{
+		NewClient: func(uri string) (*MongoClient, error) {
+			return NewMongoClientWithProm(uri, prom)
+		},
+		RetireDelay:  RegistryRetireDelay,
+		DrainTimeout: RegistryDrainTimeout,
+		entries:      make(map[string]*registryEntry),
+		life:         lifecycle.NewTracker("mongo registry"),
+		closing:      make(chan struct{}),
+	}
+}
+
+var (
+	// registryMu 保护 registry, ClientMap, CommonClient 和 IsInit.
+	// onChange 在持有 Registry.mu 时获取 registryMu, 持有 registryMu 时不能再调用 Registry 的方法
+	registryMu sync.RWMutex
+	// registry Init 和默认 GetClient 使用的全局客户端表, ClientMap 和 CommonClient 是它的只读快照
+	registry = newGlobalRegistry(false)
+)
+
+func newGlobalRegistry(prom bool) *Registry {
+	r := NewRegistry(prom)
+	r.onChange = func(entries map[string]*registryEntry) {
+		clientMap := make(map[string]*MongoClient, len(entries))
+		var common *MongoClient
+		for key, e := range entries {
+			if key == DefaultClientKey {
+				common = e.client
+			} else {
+				clientMap[key] = e.client
+			}
+		}
+		registryMu.Lock()
+		ClientMap, CommonClient = clientMap, common
+		registryMu.Unlock()
+	}
+	return r
+}
+
+// Clients 返回 Init 使用的全局客户端表, 可以用它在运行时更新和查询客户端
+func Clients() *Registry {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	return registry
+}
+
+// Client 返回 key 对应的客户端, 没有时按 Fallback 处理
+func (r *Registry) Client(key string) (*MongoClient, bool) {
+	c, err := r.Lookup(key)
+	return c, err == nil
+}
+
+// Lookup 返回 key 对应的客户端, 没有时按 Fallback 处理, 找不到返回 ErrClientNotFound
+func (r *Registry) Lookup(key string) (*MongoClient, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if e, ok := r.entries[key]; ok {
+		return e.client, nil
+	}
+	if r.Fallback == FallbackDefault {
+		if e, ok := r.entries[DefaultClientKey]; ok {
+			return e.client, nil
+		}
+	}
+	return nil, errors.Wrap(ErrClientNotFound, key)
+}
+
+// Info 返回 key 的客户端信息, 不使用 Fallback
+func (r *Registry) Info(key string) (ClientInfo, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if e, ok := r.entries[key]; ok {
+		return e.info(key), true
+	}
+	return ClientInfo{}, false
+}
+
+// Keys 按字典序返回所有客户端的 key, 包括 DefaultClientKey
+func (r *Registry) Keys() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	keys := make([]string, 0, len(r.entries))
+	for key := range r.entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Range 按 key 的字典序遍历客户端, fn 返回 false 时停止
+func (r *Registry) Range(fn func(info ClientInfo) bool) {
+	for _, key := range r.Keys() {
+		if info, ok := r.Info(key); ok && !fn(info) {
+			return
+		}
+	}
+}
+
+// SetMetadata 设置 key 的项目信息, key 不存在时返回 ErrClientNotFound
+func (r *Registry) SetMetadata(key string, metadata map[string]string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, ok := r.entries[key]
+	if !ok {
+		return errors.Wrap(ErrClientNotFound, key)
+	}
+	md := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		md[k] = v
+	}
+	// entries 中的 entry 可能被快照引用, 替换而不是修改
+	r.entries[key] = &registryEntry{uri: e.uri, client: e.client, metadata: md, addedAt: e.addedAt}
+	return nil
+}
+
+// Set 增加或替换 key 对应的客户端, 被替换的客户端会在后台关闭
+func (r *Registry) Set(key string, client *MongoClient) {
+	r.applyMu.Lock()
+	defer r.applyMu.Unlock()
+	r.swap(map[string]*registryEntry{key: {client: client}}, nil)
+}
+
+// Remove 下线 key 对应的客户端, 客户端会在后台关闭
+func (r *Registry) Remove(key string) {
+	r.applyMu.Lock()
+	defer r.applyMu.Unlock()
+	r.swap(nil, []string{key})
+}
+
+// Apply 把客户端表更新为 cfg: 新增和 uri 变化的项目并发创建新客户端, 不在 cfg 中的项目下线.
+// 创建失败的项目保留原来的客户端, 错误以 ClientErrors 返回, 其他项目照常更新
+func (r *Registry) Apply(ctx context.Context, cfg ClientConfig) error {
+	r.applyMu.Lock()
+	defer r.applyMu.Unlock()
+	if r.life.Closed() {
+		return lifecycle.ErrClosed
+	}
+
+	r.mu.RLock()
+	var removed []string
+	for key := range r.entries {
+		if _, ok := cfg[key]; !ok {
+			removed = append(removed, key)
+		}
+	}
+	changed := make(map[string]string)
+	for key, uri := range cfg {
+		if e, ok := r.entries[key]; !ok || e.uri != uri {
+			changed[key] = uri
+		}
+	}
+	r.mu.RUnlock()
+
+	var (
+		mu    sync.Mutex
+		wg    sync.WaitGroup
+		added = make(map[string]*registryEntry, len(changed))
+		errs  = ClientErrors{}
+	)
+	for key, uri := range changed {
+		wg.Add(1)
+		go func(key, uri string) {
+			defer wg.Done()
+			c, err := r.connect(ctx, uri)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs[key] = err
+				return
+			}
+			added[key] = &registryEntry{uri: uri, client: c}
+		}(key, uri)
+	}
+	wg.Wait()
+
+	r.swap(added, removed)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// LoadFile 从 json 文件读取 ClientConfig 并 Apply, 文件格式: {"default": "mongodb://...", "project": "mongodb://..."}
+func (r *Registry) LoadFile(ctx context.Context, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var cfg ClientConfig
+	if err = json.Unmarshal(data, &cfg); err != nil {
+		return fmt.Errorf("parse %s fail: %w", path, err)
+	}
+	return r.Apply(ctx, cfg)
+}
+
+// WatchFile 先 LoadFile 一次, 之后每隔 interval 检查文件, 修改后重新 LoadFile, 返回的 stop 停止检查.
+// 重新加载失败只记录日志, 已有的客户端继续使用
+func (r *Registry) WatchFile(path string, interval time.Duration) (stop func(), err error) {
+	if err = r.LoadFile(context.Background(), path); err != nil {
+		return nil, err
+	}
+	modTime, err := fileModTime(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	stop = func() {
+		cancel()
+		<-done
+	}
+	unregister, err := r.life.Register(stop)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	go func() {
+		defer close(done)
+		defer unregister()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			t, err := fileModTime(path)
+			if err != nil {
+				zenlog.Error("zmgo watch client config %s fail: %+v", path, err)
+				continue
+			}
+			if t.Equal(modTime) {
+				continue
+			}
+			modTime = t
+			if err = r.LoadFile(ctx, path); err != nil {
+				zenlog.Error("zmgo reload client config %s fail: %+v", path, err)
+				continue
+			}
+			zenlog.Info("zmgo reload client config %s success", path)
+		}
+	}()
+	return stop, nil
+}
+
+// Close 停止 WatchFile, 关闭所有客户端并等待被替换的客户端关闭
+func (r *Registry) Close(ctx context.Context) error {
+	_ = r.life.Shutdown(ctx)
+	r.closeMu.Do(func() { close(r.closing) })
+
+	r.applyMu.Lock()
+	r.mu.Lock()
+	entries := r.entries
+	r.entries = make(map[string]*registryEntry)
+	r.notify()
+	r.mu.Unlock()
+	r.applyMu.Unlock()
+
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		errs = ClientErrors{}
+	)
+	for key, e := range entries {
+		wg.Add(1)
+		go func(key string, c *MongoClient) {
+			defer wg.Done()
+			if err := c.Close(ctx); err != nil {
+				mu.Lock()
+				errs[key] = err
+				mu.Unlock()
+			}
+		}(key, e.client)
+	}
+	wg.Wait()
+
+	retired := make(chan struct{})
+	go func() {
+		r.retiring.Wait()
+		close(retired)
+	}()
+	select {
+	case <-retired:
+	case <-ctx.Done():
+		errs["retired"] = ctx.Err()
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// connect 创建客户端, NewClient 会连接并 ping, 成功才算预热完成
+func (r *Registry) connect(ctx context.Context, uri string) (*MongoClient, error) {
+	type result struct {
+		c   *MongoClient
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		c, err := r.NewClient(uri)
+		ch <- result{c, err}
+	}()
+
+	select {
+	case res := <-ch:
+		return res.c, res.err
+	case <-ctx.Done():
+		// 超时后创建成功的客户端没有人使用, 直接关闭
+		go func() {
+			if res := <-ch; res.err == nil {
+				_ = res.c.Close(context.Background())
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// swap 替换和删除客户端, 被替换的客户端在后台关闭, 调用方需要持有 applyMu
+func (r *Registry) swap(added map[string]*registryEntry, removed []string) {
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+
+	r.mu.Lock()
+	entries := make(map[string]*registryEntry, len(r.entries)+len(added))
+	for key, e := range r.entries {
+		entries[key] = e
+	}
+	var retired []*MongoClient
+	now := time.Now()
+	for key, e := range added {
+		e.addedAt = now
+		if old, ok := entries[key]; ok {
+			e.metadata = old.metadata
+			if old.client != e.client {
+				retired = append(retired, old.client)
+			}
+		}
+		entries[key] = e
+	}
+	for _, key := range removed {
+		if old, ok := entries[key]; ok {
+			retired = append(retired, old.client)
+			delete(entries, key)
+		}
+	}
+	r.entries = entries
+	r.notify()
+	r.mu.Unlock()
+
+	for _, c := range retired {
+		r.retire(c)
+	}
+}
+
+func (r *Registry) retire(c *MongoClient) {
+	r.retiring.Add(1)
+	go func() {
+		defer r.retiring.Done()
+		select {
+		case <-time.After(r.RetireDelay):
+		case <-r.closing:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), r.DrainTimeout)
+		defer cancel()
+		if err := c.Close(ctx); err != nil {
+			zenlog.Error("zmgo close retired client fail: %+v", err)
+		}
+	}()
+}
+
+// notify 调用方需要持有 mu
+func (r *Registry) notify() {
+	if r.onChange != nil {
+		r.onChange(r.entries)
+	}
+}
+
+func fileModTime(path string) (time.Time, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
@@ -0,0 +1,212 @@
+package zmgo
+
+import (
+	"context"
+	"fmt"
+	"github.com/QuRuijie/zenDB/lifecycle"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRegistry(t *testing.T) {
+	defer func() {
+		t.Log("==================TestRegistry end====================")
+	}()
+	t.Log("==================TestRegistry begin==================")
+
+	// 不连接服务器的客户端, 只检查客户端表的替换
+	r := NewRegistry(false)
+	r.RetireDelay = 0
+	r.NewClient = newTestClient
+	ctx := context.Background()
+
+	t.Log("[apply config and fall back to default]")
+	err := r.Apply(ctx, ClientConfig{DefaultClientKey: "mongodb://127.0.0.1:27017", "p1": "mongodb://127.0.0.1:27018"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	common, _ := r.Client(DefaultClientKey)
+	p1, _ := r.Client("p1")
+	if c, _ := r.Client("unknown"); c != common || p1 == common {
+		t.Fatal("client lookup Fail")
+	}
+
+	t.Log("[changed uri is swapped, failed connect keeps the rest]")
+	err = r.Apply(ctx, ClientConfig{DefaultClientKey: "mongodb://127.0.0.1:27017", "p1": "mongodb://127.0.0.1:27019", "p2": "bad"})
+	var errs ClientErrors
+	if !errors.As(err, &errs) || len(errs) != 1 || errs["p2"] == nil {
+		t.Fatalf("apply should report p2 only, got %v", err)
+	}
+	if c, _ := r.Client("p1"); c == p1 {
+		t.Fatal("p1 should be replaced")
+	}
+	if c, _ := r.Client(DefaultClientKey); c != common {
+		t.Fatal("unchanged client should be kept")
+	}
+	waitFor(t, "retired client closed", func() bool { return p1.life.Closed() })
+
+	t.Log("[watch config file]")
+	path := filepath.Join(t.TempDir(), "mongo.json")
+	if err = ioutil.WriteFile(path, []byte(`{"default": "mongodb://127.0.0.1:27017"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stop, err := r.WatchFile(path, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+	if c, _ := r.Client("p1"); c != common {
+		t.Fatal("p1 should be removed after loading the file")
+	}
+	if err = ioutil.WriteFile(path, []byte(`{"default": "mongodb://127.0.0.1:27017", "p3": "mongodb://127.0.0.1:27020"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	_ = os.Chtimes(path, future, future)
+	waitFor(t, "p3 loaded", func() bool {
+		c, _ := r.Client("p3")
+		return c != common
+	})
+
+	_ = r.Close(ctx)
+	if _, ok := r.Client(DefaultClientKey); ok {
+		t.Fatal("closed registry should be empty")
+	}
+	t.Log("TestRegistry Success")
+}
+
+// TestGlobalRegistryRace 用 -race 运行, 检查运行时更新客户端时读取全局快照是并发安全的
+func TestGlobalRegistryRace(t *testing.T) {
+	defer func() {
+		t.Log("==================TestGlobalRegistryRace end====================")
+	}()
+	t.Log("==================TestGlobalRegistryRace begin==================")
+
+	ctx := context.Background()
+	r := Clients()
+	r.RetireDelay = 0
+	r.NewClient = newTestClient
+	if err := r.Apply(ctx, ClientConfig{DefaultClientKey: "mongodb://127.0.0.1:27017"}); err != nil {
+		t.Fatal(err)
+	}
+	registryMu.Lock()
+	IsInit = true
+	registryMu.Unlock()
+	defer func() { _ = Close(ctx) }()
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if !Initialized() || GetCommonClient() == nil {
+				t.Error("common client should always be set")
+				return
+			}
+			_ = GetClientMap()["p1"]
+			if _, err := GetClient("p1"); err != nil {
+				t.Errorf("GetClient Fail: %v", err)
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 20; i++ {
+		cfg := ClientConfig{DefaultClientKey: "mongodb://127.0.0.1:27017"}
+		if i%2 == 0 {
+			cfg["p1"] = fmt.Sprintf("mongodb://127.0.0.1:%d", 28000+i)
+		}
+		if err := r.Apply(ctx, cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(done)
+	<-stopped
+	t.Log("TestGlobalRegistryRace Success")
+}
+
+func TestRegistryLookup(t *testing.T) {
+	defer func() {
+		t.Log("==================TestRegistryLookup end====================")
+	}()
+	t.Log("==================TestRegistryLookup begin==================")
+
+	client, err := mongo.NewClient(options.Client().ApplyURI(URI))
+	if err != nil {
+		t.Fatal(err)
+	}
+	common := &MongoClient{client: client}
+	p1 := &MongoClient{client: client}
+	r := NewRegistry(false)
+	r.Set(DefaultClientKey, common)
+	r.Set("p1", p1)
+
+	t.Log("[metadata survives client replacement]")
+	if err = r.SetMetadata("p1", map[string]string{"region": "us"}); err != nil {
+		t.Fatal(err)
+	}
+	r.Set("p1", &MongoClient{client: client})
+	if info, ok := r.Info("p1"); !ok || info.Metadata["region"] != "us" || info.Client == p1 {
+		t.Fatalf("metadata Fail: %+v", info)
+	}
+	if keys := r.Keys(); len(keys) != 2 || keys[0] != DefaultClientKey || keys[1] != "p1" {
+		t.Fatalf("keys Fail: %v", keys)
+	}
+
+	t.Log("[fallback policy]")
+	if c, err := r.Lookup("unknown"); err != nil || c != common {
+		t.Fatalf("fallback default Fail: %v", err)
+	}
+	r.Fallback = FallbackNone
+	if _, err = r.Lookup("unknown"); !errors.Is(err, ErrClientNotFound) {
+		t.Fatalf("fallback none should fail, got %v", err)
+	}
+
+	t.Log("[concurrent Database is safe]")
+	done := make(chan struct{})
+	for i := 0; i < 8; i++ {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			for j := 0; j < 100; j++ {
+				common.Database(DB_NAME)
+			}
+		}()
+	}
+	for i := 0; i < 8; i++ {
+		<-done
+	}
+	t.Log("TestRegistryLookup Success")
+}
+
+// newTestClient 不连接服务器的客户端, uri 为 "bad" 时模拟连接失败
+func newTestClient(uri string) (*MongoClient, error) {
+	if uri == "bad" {
+		return nil, errors.New("connect fail")
+	}
+	client, err := mongo.NewClient(options.Client().ApplyURI(uri))
+	if err != nil {
+		return nil, err
+	}
+	return &MongoClient{client: client, dbs: make(map[string]*mongo.Database), life: lifecycle.NewTracker("mongo")}, nil
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,179 @@
+package zmgo
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Repository 绑定 dbName.collName 和文档类型 T 的数据访问层, 查询结果直接是 T, 不需要传 interface{}
+type Repository[T any] struct {
+	client   *MongoClient
+	dbName   string
+	collName string
+}
+
+// NewRepository client 为 nil 时每次操作通过 GetClient(dbName) 选择客户端
+func NewRepository[T any](client *MongoClient, dbName, collName string) *Repository[T] {
+	return &Repository[T]{client: client, dbName: dbName, collName: collName}
+}
+
+func (r *Repository[T]) getClient() (*MongoClient, error) {
+	if r.client != nil {
+		return r.client, nil
+	}
+	return GetClient(r.dbName)
+}
+
+// Get 按 _id 查询, 不存在时返回 mongo.ErrNoDocuments
+func (r *Repository[T]) Get(ctx context.Context, id interface{}) (*T, error) {
+	return r.FindOne(ctx, bson.M{"_id": id})
+}
+
+func (r *Repository[T]) FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) (*T, error) {
+	c, err := r.getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	doc := new(T)
+	if err = c.FindOneCtx(ctx, doc, r.dbName, r.collName, filter, opts...); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func (r *Repository[T]) Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) ([]T, error) {
+	c, err := r.getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	docs := make([]T, 0)
+	if err = c.FindAllCtx(ctx, &docs, r.dbName, r.collName, filter, opts...); err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
+
+// ForEach 流式遍历查询结果, fn 返回 error 时停止
+func (r *Repository[T]) ForEach(ctx context.Context, filter interface{}, fn func(doc *T) error, opts ...*options.FindOptions) error {
+	c, err := r.getClient()
+	if err != nil {
+		return err
+	}
+
+	iter, err := c.FindIter(ctx, r.dbName, r.collName, filter, opts...)
+	if err != nil {
+		return err
+	}
+	return iter.ForEach(func(it *Iter) error {
+		doc := new(T)
+		if err := it.Decode(doc); err != nil {
+			return err
+		}
+		return fn(doc)
+	})
+}
+
+// Insert 返回插入文档的 _id
+func (r *Repository[T]) Insert(ctx context.Context, doc *T) (interface{}, error) {
+	c, err := r.getClient()
+	if err != nil {
+		return nil, err
+	}
+	return c.InsertOneCtx(ctx, r.dbName, r.collName, doc)
+}
+
+func (r *Repository[T]) InsertMany(ctx context.Context, docs []T) ([]interface{}, error) {
+	c, err := r.getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	documents := make([]interface{}, 0, len(docs))
+	for i := range docs {
+		documents = append(documents, &docs[i])
+	}
+	result, err := c.InsertManyCtx(ctx, r.dbName, r.collName, documents)
+	if err != nil {
+		return nil, err
+	}
+	return result.InsertedIDs, nil
+}
+
+// Update 按 _id 更新, update 需要包含更新操作符, 如 {"$set": ...}
+func (r *Repository[T]) Update(ctx context.Context, id interface{}, update interface{}, opts ...*options.UpdateOptions) (*WriteResult, error) {
+	c, err := r.getClient()
+	if err != nil {
+		return nil, err
+	}
+	return c.UpdateOneWithResult(ctx, r.dbName, r.collName, bson.M{"_id": id}, update, opts...)
+}
+
+func (r *Repository[T]) UpdateMany(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*WriteResult, error) {
+	c, err := r.getClient()
+	if err != nil {
+		return nil, err
+	}
+	return c.UpdateAllWithResult(ctx, r.dbName, r.collName, filter, update, opts...)
+}
+
+// Replace 按 _id 用 doc 整个替换文档
+func (r *Repository[T]) Replace(ctx context.Context, id interface{}, doc *T) (*WriteResult, error) {
+	c, err := r.getClient()
+	if err != nil {
+		return nil, err
+	}
+	return c.ReplaceOneWithResult(ctx, r.dbName, r.collName, bson.M{"_id": id}, doc)
+}
+
+// Delete 按 _id 删除, 返回是否删除了文档
+func (r *Repository[T]) Delete(ctx context.Context, id interface{}) (bool, error) {
+	c, err := r.getClient()
+	if err != nil {
+		return false, err
+	}
+
+	result, err := c.DeleteOneWithResult(ctx, r.dbName, r.collName, bson.M{"_id": id})
+	if err != nil {
+		return false, err
+	}
+	return result.DeletedCount > 0, nil
+}
+
+func (r *Repository[T]) DeleteMany(ctx context.Context, filter interface{}) (int64, error) {
+	c, err := r.getClient()
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := c.DeleteManyWithResult(ctx, r.dbName, r.collName, filter)
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+func (r *Repository[T]) Count(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error) {
+	c, err := r.getClient()
+	if err != nil {
+		return 0, err
+	}
+	return c.CountCtx(ctx, r.dbName, r.collName, filter, opts...)
+}
+
+func (r *Repository[T]) Exists(ctx context.Context, filter interface{}) (bool, error) {
+	count, err := r.Count(ctx, filter, options.Count().SetLimit(1))
+	return count > 0, err
+}
+
+// Collection 返回底层的 *mongo.Collection, 用于 Repository 没有封装的操作
+func (r *Repository[T]) Collection() (*mongo.Collection, error) {
+	c, err := r.getClient()
+	if err != nil {
+		return nil, err
+	}
+	return c.DbColl(r.dbName, r.collName), nil
+}
@@ -0,0 +1,170 @@
+package zmgo
+
+import (
+	"context"
+	"github.com/QuRuijie/zenDB/prom"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"math/rand"
+	"time"
+)
+
+// RetryClass 错误的重试分类
+type RetryClass int
+
+const (
+	// RetryNever 不可重试的错误, 如参数错误, 唯一键冲突
+	RetryNever RetryClass = iota
+	// RetrySafe 服务器没有执行该操作, 读写都可以重试, 如 NotPrimary, WriteConflict
+	RetrySafe
+	// RetryIfIdempotent 操作可能已经执行, 如发送后断线, 只有读和声明为幂等的写可以重试
+	RetryIfIdempotent
+)
+
+// 可以安全重试的服务器错误码, 操作在服务器上没有执行
+var safeRetryCodes = []int{
+	10107, // NotWritablePrimary
+	13435, // NotPrimaryNoSecondaryOk
+	13436, // NotPrimaryOrSecondary
+	189,   // PrimarySteppedDown
+	91,    // ShutdownInProgress
+	112,   // WriteConflict
+}
+
+// 操作结果未知的服务器错误码, 写操作可能已经执行
+var ambiguousRetryCodes = []int{
+	11600, // InterruptedAtShutdown
+	11602, // InterruptedDueToReplStateChange
+	6,     // HostUnreachable
+	7,     // HostNotFound
+	89,    // NetworkTimeout
+	9001,  // SocketException
+}
+
+// RetryPolicy MongoClient 的重试策略, 事务内的操作不会重试, 由 WithTransaction 整体重试.
+// 驱动默认开启 retryWrites/retryReads, 每次尝试内部还会对可重试的错误再重试一次, 最坏情况下服务器收到 2*MaxAttempts 次请求.
+// 需要 MaxAttempts 就是总次数时, 在 uri 中加上 retryWrites=false&retryReads=false;
+// 驱动重试的写带有事务号, 服务器保证只执行一次, 关闭后发送后断线的非幂等写只能失败, 不会被本策略重试
+type RetryPolicy struct {
+	// MaxAttempts 包括第一次在内的最多尝试次数, 小于等于 1 时不重试
+	MaxAttempts int
+	// BaseDelay 第一次重试前的最长等待, 之后每次翻倍, 实际等待在 [0, delay) 内随机
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Classify 自定义错误分类, 为空时使用 DefaultRetryClassify
+	Classify func(err error) RetryClass
+}
+
+// DefaultRetryPolicy 最多尝试 3 次, 退避 50ms 起, 最长 1s
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{MaxAttempts: 3, BaseDelay: 50 * time.Millisecond, MaxDelay: time.Second}
+}
+
+// SetRetryPolicy 设置重试策略, nil 表示不重试, 需要在客户端开始使用前设置, 与驱动自带重试的关系见 RetryPolicy
+func (c *MongoClient) SetRetryPolicy(p *RetryPolicy) {
+	c.retry = p
+}
+
+// DefaultRetryClassify 网络错误和主从切换类错误可以重试, 其中发送后断线的写操作只在幂等时重试
+func DefaultRetryClassify(err error) RetryClass {
+	var se mongo.ServerError
+	if errors.As(err, &se) {
+		for _, code := range safeRetryCodes {
+			if se.HasErrorCode(code) {
+				return RetrySafe
+			}
+		}
+		for _, code := range ambiguousRetryCodes {
+			if se.HasErrorCode(code) {
+				return RetryIfIdempotent
+			}
+		}
+		if se.HasErrorLabel("RetryableWriteError") {
+			return RetryIfIdempotent
+		}
+	}
+	if mongo.IsNetworkError(err) {
+		return RetryIfIdempotent
+	}
+	return RetryNever
+}
+
+type idempotentKey struct{}
+
+// WithIdempotent 声明 ctx 上的写操作是幂等的(如 $set 或指定 _id 的插入), 结果未知时也可以重试
+func WithIdempotent(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idempotentKey{}, true)
+}
+
+func isIdempotent(ctx context.Context) bool {
+	v, _ := ctx.Value(idempotentKey{}).(bool)
+	return v
+}
+
+func inTransaction(ctx context.Context) bool {
+	sess := mongo.SessionFromContext(ctx)
+	if sess == nil {
+		return false
+	}
+	xs, ok := sess.(mongo.XSession)
+	return ok && xs.ClientSession().TransactionRunning()
+}
+
+// withRetry 按重试策略执行 fn, 每次尝试都经过熔断器, 客户端关闭后返回 lifecycle.ErrClosed, write 为 true 时结果未知的错误只有在 WithIdempotent 下才重试
+func (c *MongoClient) withRetry(ctx context.Context, method string, write bool, fn func() error) error {
+	// 事务内的操作由 WithTransaction 整体记录, 关闭时需要让事务执行完
+	if !inTransaction(ctx) {
+		if err := c.life.Begin(method); err != nil {
+			return err
+		}
+		defer c.life.End(method)
+	}
+
+	p := c.retry
+	if p == nil || p.MaxAttempts <= 1 || inTransaction(ctx) {
+		return c.withBreaker(fn)
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := c.withBreaker(fn)
+		if err == nil || attempt >= p.MaxAttempts || ctx.Err() != nil {
+			return err
+		}
+
+		class := p.classify(err)
+		if class == RetryNever || (write && class == RetryIfIdempotent && !isIdempotent(ctx)) {
+			return err
+		}
+
+		if c.prom {
+			prom.SetMongoRetryMetrics(method)
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(p.backoff(attempt)):
+		}
+	}
+}
+
+func (p *RetryPolicy) classify(err error) RetryClass {
+	if p.Classify != nil {
+		return p.Classify(err)
+	}
+	return DefaultRetryClassify(err)
+}
+
+// backoff 指数退避加 full jitter
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && (p.MaxDelay <= 0 || delay < p.MaxDelay); i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(delay)))
+}
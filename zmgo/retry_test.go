@@ -0,0 +1,64 @@
+package zmgo
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/mongo"
+	"testing"
+	"time"
+)
+
+func TestWithRetry(t *testing.T) {
+	defer func() {
+		t.Log("==================TestWithRetry end====================")
+	}()
+	t.Log("==================TestWithRetry begin==================")
+
+	c := &MongoClient{retry: &RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}}
+	ctx := context.Background()
+	notPrimary := mongo.CommandError{Code: 10107, Name: "NotWritablePrimary"}
+	interrupted := mongo.CommandError{Code: 11600, Name: "InterruptedAtShutdown"}
+
+	t.Log("[NotPrimary is safe to retry for writes]")
+	attempts := 0
+	err := c.withRetry(ctx, "UpdateOne", true, func() error {
+		attempts++
+		if attempts < 3 {
+			return notPrimary
+		}
+		return nil
+	})
+	if err != nil || attempts != 3 {
+		t.Fatalf("retry NotPrimary Fail, attempts: %d, err: %v", attempts, err)
+	}
+
+	t.Log("[ambiguous error must not retry non-idempotent writes]")
+	attempts = 0
+	err = c.withRetry(ctx, "UpdateOne", true, func() error {
+		attempts++
+		return interrupted
+	})
+	if err == nil || attempts != 1 {
+		t.Fatalf("non-idempotent write retried, attempts: %d", attempts)
+	}
+
+	t.Log("[ambiguous error retries idempotent writes until MaxAttempts]")
+	attempts = 0
+	err = c.withRetry(WithIdempotent(ctx), "UpdateOne", true, func() error {
+		attempts++
+		return interrupted
+	})
+	if err == nil || attempts != 3 {
+		t.Fatalf("idempotent write retry Fail, attempts: %d", attempts)
+	}
+
+	t.Log("[duplicate key is never retried]")
+	attempts = 0
+	_ = c.withRetry(ctx, "FindOne", false, func() error {
+		attempts++
+		return mongo.CommandError{Code: 11000}
+	})
+	if attempts != 1 {
+		t.Fatalf("non retryable error retried, attempts: %d", attempts)
+	}
+	t.Log("TestWithRetry Success")
+}
@@ -0,0 +1,126 @@
+package zmgo
+
+import (
+	"context"
+	"encoding/base64"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+var ErrInvalidSessionToken = errors.New("invalid session token")
+
+// SessionState 因果一致性需要在请求之间传递的时间点
+type SessionState struct {
+	ClusterTime   bson.Raw             `bson:"ct,omitempty"`
+	OperationTime *primitive.Timestamp `bson:"ot,omitempty"`
+}
+
+// CausalSession 开启因果一致性的 session, 同一个 session 上后面的读一定能读到前面的写, 即使读的是从节点.
+// 通过 Context 返回的 ctx 调用 zmgo 的 *Ctx 方法, 操作就会绑定到该 session 上.
+// 没有指定读写关注时 session 上的操作使用 majority, 这是因果一致性生效的前提.
+// CausalSession 不能并发使用, 用完需要调用 End
+type CausalSession struct {
+	client *MongoClient
+	sess   mongo.Session
+}
+
+// --------------------------------- Method without Client ---------------------------------------
+
+func StartCausalSession(dbName, token string) (*CausalSession, error) {
+	c, err := GetClient(dbName)
+	if err != nil {
+		return nil, err
+	}
+	return c.StartCausalSession(token)
+}
+
+// --------------------------------- Method with Client --------------------------------------------
+
+// StartCausalSession token 不为空时从其他请求或服务传来的 Token 继续, 读操作会等到 token 之后的写可见
+func (c *MongoClient) StartCausalSession(token string) (*CausalSession, error) {
+	var state SessionState
+	if token != "" {
+		var err error
+		if state, err = DecodeSessionToken(token); err != nil {
+			return nil, err
+		}
+	}
+
+	sess, err := c.client.StartSession(options.Session().SetCausalConsistency(true))
+	if err != nil {
+		return nil, err
+	}
+	s := &CausalSession{client: c, sess: sess}
+	if err = s.Advance(state); err != nil {
+		sess.EndSession(context.Background())
+		return nil, err
+	}
+	return s, nil
+}
+
+// Context 返回绑定了该 session 的 ctx
+func (s *CausalSession) Context(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(readWriteOptionsKey{}).(*ReadWriteOptions); !ok {
+		ctx = WithReadWriteOptions(ctx, ReadWriteOptions{
+			ReadConcern:  readconcern.Majority(),
+			WriteConcern: writeconcern.New(writeconcern.WMajority()),
+		})
+	}
+	return mongo.NewSessionContext(ctx, s.sess)
+}
+
+func (s *CausalSession) State() SessionState {
+	return SessionState{ClusterTime: s.sess.ClusterTime(), OperationTime: s.sess.OperationTime()}
+}
+
+// Advance 合并其他 session 的时间点, 只会前进不会后退
+func (s *CausalSession) Advance(state SessionState) error {
+	if state.ClusterTime != nil {
+		if err := s.sess.AdvanceClusterTime(state.ClusterTime); err != nil {
+			return err
+		}
+	}
+	if state.OperationTime != nil {
+		if err := s.sess.AdvanceOperationTime(state.OperationTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Token 序列化当前时间点, 传给其他请求或服务后用 StartCausalSession 继续
+func (s *CausalSession) Token() (string, error) {
+	return s.State().Token()
+}
+
+func (s *CausalSession) End(ctx context.Context) {
+	s.sess.EndSession(ctx)
+}
+
+// Token 编码为 base64url 字符串, 没有任何时间点时返回空字符串
+func (st SessionState) Token() (string, error) {
+	if st.ClusterTime == nil && st.OperationTime == nil {
+		return "", nil
+	}
+	raw, err := bson.Marshal(st)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func DecodeSessionToken(token string) (state SessionState, err error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return state, ErrInvalidSessionToken
+	}
+	if err = bson.Unmarshal(raw, &state); err != nil {
+		return state, ErrInvalidSessionToken
+	}
+	return state, nil
+}
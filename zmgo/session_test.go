@@ -0,0 +1,37 @@
+package zmgo
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"testing"
+)
+
+func TestSessionToken(t *testing.T) {
+	defer func() {
+		t.Log("==================TestSessionToken end====================")
+	}()
+	t.Log("==================TestSessionToken begin==================")
+
+	clusterTime, _ := bson.Marshal(bson.M{"$clusterTime": bson.M{"clusterTime": primitive.Timestamp{T: 100, I: 2}}})
+	state := SessionState{ClusterTime: clusterTime, OperationTime: &primitive.Timestamp{T: 100, I: 1}}
+	token, err := state.Token()
+	if err != nil || token == "" {
+		t.Fatalf("encode token Fail: %q, err: %v", token, err)
+	}
+
+	decoded, err := DecodeSessionToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.OperationTime.Equal(*state.OperationTime) || string(decoded.ClusterTime) != string(clusterTime) {
+		t.Fatalf("decode token Fail: %+v", decoded)
+	}
+
+	if token, _ = (SessionState{}).Token(); token != "" {
+		t.Fatalf("empty state should encode to empty token, got %q", token)
+	}
+	if _, err = DecodeSessionToken("not a token"); err != ErrInvalidSessionToken {
+		t.Fatalf("invalid token should fail, got %v", err)
+	}
+	t.Log("TestSessionToken Success")
+}
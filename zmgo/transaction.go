@@ -0,0 +1,133 @@
+package zmgo
+
+import (
+	"context"
+	"github.com/QuRuijie/zenDB/prom"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"time"
+)
+
+const (
+	// TransactionRetryTimeLimit is the longest time WithTransaction keeps retrying
+	// a transaction that failed with TransientTransactionError or whose commit
+	// failed with UnknownTransactionCommitResult, the same limit the driver uses.
+	TransactionRetryTimeLimit = 120 * time.Second
+
+	transientTransactionError      = "TransientTransactionError"
+	unknownTransactionCommitResult = "UnknownTransactionCommitResult"
+)
+
+// commitBackoff 重试提交前的退避, 给服务端选主或恢复网络的时间
+var commitBackoff = &RetryPolicy{BaseDelay: 50 * time.Millisecond, MaxDelay: time.Second}
+
+// TransactionFunc 事务回调, 在回调里把sessCtx传给zmgo的*Ctx方法, 这些操作就会绑定到同一个事务上
+type TransactionFunc func(sessCtx mongo.SessionContext) error
+
+// --------------------------------- Method without Client ---------------------------------------
+
+func WithTransaction(ctx context.Context, dbName string, fn TransactionFunc, opts ...*options.TransactionOptions) error {
+	c, err := GetClient(dbName)
+	if err != nil {
+		return err
+	}
+	return c.WithTransaction(ctx, fn, opts...)
+}
+
+// --------------------------------- Method with Client --------------------------------------------
+
+// WithTransaction 开启session并在事务中执行fn, 遇到TransientTransactionError会重试整个事务,
+// 提交时遇到UnknownTransactionCommitResult会重试提交, fn返回error时事务回滚
+func (c *MongoClient) WithTransaction(ctx context.Context, fn TransactionFunc, opts ...*options.TransactionOptions) (err error) {
+	defer func(startTime int64) { c.promMonitor(ctx, startTime, "WithTransaction", err) }(prom.NowMicrosecond())
+
+	if err = c.life.Begin("WithTransaction"); err != nil {
+		return err
+	}
+	defer c.life.End("WithTransaction")
+
+	sess, err := c.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer sess.EndSession(context.Background())
+
+	// 事务只能在 primary 上执行, 没有指定时不能继承客户端默认的 readpref.Nearest()
+	txnOpts := options.MergeTransactionOptions(opts...)
+	if txnOpts.ReadPreference == nil {
+		txnOpts.SetReadPreference(readpref.Primary())
+	}
+	deadline := time.Now().Add(TransactionRetryTimeLimit)
+	canRetry := func() bool {
+		return ctx.Err() == nil && time.Now().Before(deadline)
+	}
+
+	for {
+		if err = sess.StartTransaction(txnOpts); err != nil {
+			return err
+		}
+
+		err = fn(mongo.NewSessionContext(ctx, sess))
+		if err != nil {
+			c.abortTransaction(sess)
+			if hasErrorLabel(err, transientTransactionError) && canRetry() {
+				continue
+			}
+			return err
+		}
+
+		// 提交过的事务不能再回滚, 提交失败时服务端已经丢弃了事务
+		err = c.commitTransaction(ctx, sess, canRetry)
+		if err == nil {
+			return nil
+		}
+		if hasErrorLabel(err, transientTransactionError) && canRetry() {
+			continue
+		}
+		return err
+	}
+}
+
+func (c *MongoClient) commitTransaction(ctx context.Context, sess mongo.Session, canRetry func() bool) (err error) {
+	for attempt := 1; ; attempt++ {
+		err = sess.CommitTransaction(ctx)
+		if err == nil {
+			c.promTransaction(prom.TransactionCommit)
+			return nil
+		}
+
+		var cmdErr mongo.CommandError
+		if errors.As(err, &cmdErr) && cmdErr.IsMaxTimeMSExpiredError() {
+			return err
+		}
+		if !hasErrorLabel(err, unknownTransactionCommitResult) || !canRetry() {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(commitBackoff.backoff(attempt)):
+		}
+	}
+}
+
+// abortTransaction 回滚事务, 事务已经结束时AbortTransaction返回的错误可以忽略, 只有回滚成功才计数
+func (c *MongoClient) abortTransaction(sess mongo.Session) {
+	if err := sess.AbortTransaction(context.Background()); err == nil {
+		c.promTransaction(prom.TransactionAbort)
+	}
+}
+
+func (c *MongoClient) promTransaction(result string) {
+	if c.prom {
+		prom.SetMongoTransactionMetrics(result)
+	}
+}
+
+func hasErrorLabel(err error, label string) bool {
+	var se mongo.ServerError
+	return errors.As(err, &se) && se.HasErrorLabel(label)
+}
@@ -0,0 +1,361 @@
+package zmgo
+
+import (
+	"bytes"
+	"context"
+	"github.com/QuRuijie/zenDB/prom"
+	"github.com/Zentertain/zenlog"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"sync"
+	"time"
+)
+
+const (
+	// WatchRetryInterval is the default wait before a subscription reopens its change stream
+	WatchRetryInterval = time.Second
+
+	errorCodeInvalidResumeToken      = 260
+	errorCodeChangeStreamFatal       = 280
+	errorCodeChangeStreamHistoryLost = 286
+	nonResumableChangeStreamError    = "NonResumableChangeStreamError"
+)
+
+// ErrWatchInvalidated 集合被删除或重命名, change stream 收到 invalidate 事件后失效, 订阅停止
+var ErrWatchInvalidated = errors.New("change stream invalidated")
+
+// ----------------------------------- Change Event -----------------------------------
+
+type ChangeNamespace struct {
+	DB   string `bson:"db"`
+	Coll string `bson:"coll"`
+}
+
+type UpdateDescription struct {
+	UpdatedFields bson.Raw `bson:"updatedFields"`
+	RemovedFields []string `bson:"removedFields"`
+}
+
+// ChangeEvent change stream 推送的事件, FullDocument 只有 insert/replace 和 UpdateLookup 下的 update 才有
+type ChangeEvent struct {
+	ID                bson.Raw            `bson:"_id"`
+	OperationType     string              `bson:"operationType"`
+	ClusterTime       primitive.Timestamp `bson:"clusterTime"`
+	Ns                ChangeNamespace     `bson:"ns"`
+	DocumentKey       bson.Raw            `bson:"documentKey"`
+	FullDocument      bson.Raw            `bson:"fullDocument"`
+	UpdateDescription *UpdateDescription  `bson:"updateDescription"`
+
+	raw bson.Raw
+}
+
+// Decode 把整个事件解码到调用方自定义的结构体
+func (e *ChangeEvent) Decode(v interface{}) error {
+	return bson.Unmarshal(e.raw, v)
+}
+
+// DecodeFullDocument 把 fullDocument 解码到调用方的文档结构体
+func (e *ChangeEvent) DecodeFullDocument(v interface{}) error {
+	if len(e.FullDocument) == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return bson.Unmarshal(e.FullDocument, v)
+}
+
+// ChangeHandler 处理事件, 返回error时订阅停止并且不保存该事件的resume token, 重新订阅时会再次收到该事件.
+// invalidate 事件也会交给 handler, 之后订阅以 ErrWatchInvalidated 停止
+type ChangeHandler func(event *ChangeEvent) error
+
+// ----------------------------------- Resume Token Store -----------------------------------
+
+// ResumeTokenStore 持久化 resume token, Load 没有数据时返回 nil, nil
+type ResumeTokenStore interface {
+	Load(ctx context.Context, key string) (bson.Raw, error)
+	Save(ctx context.Context, key string, token bson.Raw) error
+}
+
+type mongoTokenStore struct {
+	client   *MongoClient
+	dbName   string
+	collName string
+}
+
+type resumeTokenDoc struct {
+	Key       string    `bson:"_id"`
+	Token     bson.Raw  `bson:"token"`
+	UpdatedAt time.Time `bson:"updatedAt"`
+}
+
+// NewMongoTokenStore resume token 保存在 dbName.collName 中, 每个订阅一条文档
+func NewMongoTokenStore(client *MongoClient, dbName, collName string) ResumeTokenStore {
+	return &mongoTokenStore{client: client, dbName: dbName, collName: collName}
+}
+
+func (s *mongoTokenStore) Load(ctx context.Context, key string) (bson.Raw, error) {
+	var doc resumeTokenDoc
+	err := s.client.FindOneCtx(ctx, &doc, s.dbName, s.collName, bson.M{"_id": key})
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return doc.Token, nil
+}
+
+func (s *mongoTokenStore) Save(ctx context.Context, key string, token bson.Raw) error {
+	update := bson.M{"$set": bson.M{"token": token, "updatedAt": time.Now()}}
+	return s.client.UpsertOneCtx(ctx, s.dbName, s.collName, bson.M{"_id": key}, update)
+}
+
+// ----------------------------------- Subscription -----------------------------------
+
+type WatchOptions struct {
+	// TokenStore 为空时 resume token 只保存在内存中, 断线后可以恢复, 进程重启后从当前时间开始订阅
+	TokenStore ResumeTokenStore
+	// TokenKey resume token 的 key, 默认是 "dbName.collName"
+	TokenKey string
+	// FullDocument 默认 options.UpdateLookup
+	FullDocument  options.FullDocument
+	BatchSize     int32
+	RetryInterval time.Duration
+}
+
+type Subscription struct {
+	client   *MongoClient
+	dbName   string
+	collName string
+	pipeline interface{}
+	handler  ChangeHandler
+	opts     WatchOptions
+
+	cancel     context.CancelFunc
+	unregister func()
+	done       chan struct{}
+	mu         sync.Mutex
+	err        error
+	// token 最近处理到的 resume token, 只在 open 和 run 中访问
+	token bson.Raw
+}
+
+func Watch(dbName, collName string, pipeline interface{}, handler ChangeHandler, opts ...*WatchOptions) (*Subscription, error) {
+	c, err := GetClient(dbName)
+	if err != nil {
+		return nil, err
+	}
+	return c.Watch(dbName, collName, pipeline, handler, opts...)
+}
+
+// Watch 订阅集合的 change stream, 断线后从最近保存的 resume token 自动恢复, 调用 Close 停止订阅
+func (c *MongoClient) Watch(dbName, collName string, pipeline interface{}, handler ChangeHandler, opts ...*WatchOptions) (*Subscription, error) {
+	if pipeline == nil {
+		pipeline = mongo.Pipeline{}
+	}
+
+	s := &Subscription{
+		client:   c,
+		dbName:   dbName,
+		collName: collName,
+		pipeline: pipeline,
+		handler:  handler,
+		done:     make(chan struct{}),
+	}
+	if len(opts) > 0 && opts[0] != nil {
+		s.opts = *opts[0]
+	}
+	if s.opts.TokenKey == "" {
+		s.opts.TokenKey = dbName + "." + collName
+	}
+	if s.opts.FullDocument == "" {
+		s.opts.FullDocument = options.UpdateLookup
+	}
+	if s.opts.RetryInterval <= 0 {
+		s.opts.RetryInterval = WatchRetryInterval
+	}
+
+	var err error
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+
+	// 客户端关闭时停止订阅
+	if s.unregister, err = c.life.Register(func() { _ = s.Close() }); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	// 先同步打开一次, 配置错误可以直接返回给调用方
+	cs, err := s.open(ctx)
+	if err != nil {
+		cancel()
+		s.unregister()
+		return nil, err
+	}
+
+	go s.run(ctx, cs)
+	return s, nil
+}
+
+// Close 停止订阅并等待处理中的事件结束
+func (s *Subscription) Close() error {
+	s.cancel()
+	<-s.done
+	return s.Err()
+}
+
+// Done 订阅结束(Close, handler 返回 error 或 change stream 不能恢复)时关闭
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err 返回导致订阅停止的错误: handler 的错误, 事件解码错误, ErrWatchInvalidated 或不能恢复的 change stream 错误
+func (s *Subscription) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// open 优先从内存中最近的 resume token 恢复, 没有时从 TokenStore 加载
+func (s *Subscription) open(ctx context.Context) (*mongo.ChangeStream, error) {
+	csOpts := options.ChangeStream().SetFullDocument(s.opts.FullDocument)
+	if s.opts.BatchSize > 0 {
+		csOpts.SetBatchSize(s.opts.BatchSize)
+	}
+	token := s.token
+	if token == nil && s.opts.TokenStore != nil {
+		var err error
+		if token, err = s.opts.TokenStore.Load(ctx, s.opts.TokenKey); err != nil {
+			return nil, err
+		}
+	}
+	if token != nil {
+		csOpts.SetResumeAfter(token)
+	}
+	return s.client.DbColl(s.dbName, s.collName).Watch(ctx, s.pipeline, csOpts)
+}
+
+func (s *Subscription) run(ctx context.Context, cs *mongo.ChangeStream) {
+	defer close(s.done)
+	defer s.unregister()
+
+	for {
+		err := s.consume(ctx, cs)
+		cs.Close(context.Background())
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			s.stop(err)
+			return
+		}
+
+		// 断线或 change stream 失效, 等待后用最近的 resume token 重新打开
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(s.opts.RetryInterval):
+			}
+
+			cs, err = s.open(ctx)
+			if err == nil {
+				break
+			}
+			s.promError()
+			if isWatchFatal(err) {
+				s.stop(err)
+				return
+			}
+			zenlog.Error("zmgo watch %s.%s reopen fail: %+v", s.dbName, s.collName, err)
+		}
+	}
+}
+
+// stop 记录导致订阅停止的错误
+func (s *Subscription) stop(err error) {
+	zenlog.Error("zmgo watch %s.%s stopped: %+v", s.dbName, s.collName, err)
+	s.mu.Lock()
+	s.err = err
+	s.mu.Unlock()
+	s.cancel()
+}
+
+// consume 读取事件直到 change stream 出错或 ctx 结束. 返回 handler 的错误, 事件解码错误,
+// ErrWatchInvalidated 和不能恢复的 change stream 错误, 其他错误返回 nil 由 run 重新打开
+func (s *Subscription) consume(ctx context.Context, cs *mongo.ChangeStream) error {
+	for {
+		if !cs.TryNext(ctx) {
+			if err := cs.Err(); err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				s.promError()
+				if isWatchFatal(err) {
+					return err
+				}
+				zenlog.Error("zmgo watch %s.%s change stream fail: %+v", s.dbName, s.collName, err)
+				return nil
+			}
+			if cs.ID() == 0 {
+				return nil
+			}
+			// 没有事件时 resume token 也会前进, 保存后重新打开不需要从很早的位置开始
+			s.saveToken(ctx, cs.ResumeToken())
+			continue
+		}
+
+		event := &ChangeEvent{}
+		if err := cs.Decode(event); err != nil {
+			// 同一个事件重新订阅后仍然无法解码, 停止订阅
+			return errors.Wrap(err, "decode change event")
+		}
+		event.raw = append(bson.Raw(nil), cs.Current...)
+
+		if err := s.handler(event); err != nil {
+			return err
+		}
+		if event.OperationType == "invalidate" {
+			return ErrWatchInvalidated
+		}
+		s.saveToken(ctx, cs.ResumeToken())
+		s.promEvent(event)
+	}
+}
+
+// saveToken token 变化时更新内存中的 token 并保存到 TokenStore
+func (s *Subscription) saveToken(ctx context.Context, token bson.Raw) {
+	if token == nil || bytes.Equal(token, s.token) {
+		return
+	}
+	s.token = append(bson.Raw(nil), token...)
+	if s.opts.TokenStore != nil {
+		if err := s.opts.TokenStore.Save(ctx, s.opts.TokenKey, s.token); err != nil && ctx.Err() == nil {
+			zenlog.Error("zmgo watch %s.%s save resume token fail: %+v", s.dbName, s.collName, err)
+		}
+	}
+}
+
+// isWatchFatal 重新打开也不能恢复的错误, 如 resume token 已经不在 oplog 中
+func isWatchFatal(err error) bool {
+	var se mongo.ServerError
+	if !errors.As(err, &se) {
+		return false
+	}
+	return se.HasErrorCode(errorCodeInvalidResumeToken) || se.HasErrorCode(errorCodeChangeStreamFatal) ||
+		se.HasErrorCode(errorCodeChangeStreamHistoryLost) || se.HasErrorLabel(nonResumableChangeStreamError)
+}
+
+func (s *Subscription) promEvent(event *ChangeEvent) {
+	if s.client.prom {
+		lag := time.Since(time.Unix(int64(event.ClusterTime.T), 0)).Seconds()
+		prom.SetMongoChangeStreamMetrics(s.dbName, s.collName, lag)
+	}
+}
+
+func (s *Subscription) promError() {
+	if s.client.prom {
+		prom.SetMongoChangeStreamErrorMetrics(s.dbName, s.collName)
+	}
+}
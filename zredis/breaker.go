@@ -0,0 +1,60 @@
+package zredis
+
+import (
+	"github.com/QuRuijie/zenDB/breaker"
+	"github.com/QuRuijie/zenDB/prom"
+	"github.com/go-redis/redis"
+	"time"
+)
+
+// SetCircuitBreaker 为客户端开启熔断器, 以 clientName 作为 prom 指标的标签,
+// 熔断时所有封装的命令直接返回 *breaker.OpenError, 需要在客户端开始使用前设置
+func (c *RedisClient) SetCircuitBreaker(cfg breaker.Config) {
+	if cfg.IsFailure == nil {
+		cfg.IsFailure = isRedisFailure
+	}
+	c.breaker = breaker.New("redis", c.clientName, cfg)
+}
+
+// CircuitBreaker 返回客户端的熔断器, 没有开启时返回 nil
+func (c RedisClient) CircuitBreaker() *breaker.Breaker {
+	return c.breaker
+}
+
+// allow 命令执行前调用, 客户端关闭后返回 lifecycle.ErrClosed, 熔断时返回 *breaker.OpenError.
+// 被拒绝的命令只记录 prom 指标; 放行的命令必须在结束时调用 report
+func (c RedisClient) allow(method string) error {
+	err := c.admit(method)
+	if err != nil {
+		c.setMetrics(prom.NowMicrosecond(), method, err)
+	}
+	return err
+}
+
+// admit 同 allow, 不记录被拒绝命令的指标
+func (c RedisClient) admit(method string) error {
+	if err := c.life.Begin(method); err != nil {
+		return err
+	}
+	if c.breaker == nil {
+		return nil
+	}
+	if err := c.breaker.Allow(); err != nil {
+		c.life.End(method)
+		return err
+	}
+	return nil
+}
+
+// report 上报 allow 放行的命令, 每个放行的命令恰好调用一次
+func (c RedisClient) report(start int64, method string, err error) {
+	c.life.End(method)
+	if c.breaker != nil {
+		c.breaker.Report(time.Duration(prom.NowMicrosecond()-start)*time.Microsecond, err)
+	}
+}
+
+// isRedisFailure key 不存在(redis.Nil)是正常结果, 不代表 redis 异常
+func isRedisFailure(err error) bool {
+	return err != nil && err != redis.Nil
+}
@@ -0,0 +1,14 @@
+package zredis
+
+import "context"
+
+// Shutdown 拒绝新的命令(返回 lifecycle.ErrClosed), 等待进行中的命令结束后关闭连接池.
+// ctx 到期时直接关闭连接池, 并返回 *lifecycle.UnfinishedError 说明哪些命令没有完成.
+// 内嵌的 Close() 不等待进行中的命令
+func (c *RedisClient) Shutdown(ctx context.Context) error {
+	unfinished := c.life.Shutdown(ctx)
+	if err := c.Client.Close(); err != nil && unfinished == nil {
+		return err
+	}
+	return unfinished
+}
@@ -0,0 +1,107 @@
+package zredis
+
+import (
+	"github.com/QuRuijie/zenDB/lifecycle"
+	"github.com/Zentertain/zenlog"
+	"github.com/go-redis/redis"
+	"math/rand"
+	"time"
+)
+
+// ConnectOptions 创建客户端时的连接方式
+type ConnectOptions struct {
+	Prom bool
+	// MaxRetries 启动时 ping 失败后的重试次数
+	MaxRetries int
+	// MinBackoff 第一次重试前的最长等待, 之后每次翻倍, 不超过 MaxBackoff, 实际等待在 [0, backoff) 内随机
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Lazy 创建时不 ping, 第一次执行命令时才建立连接, redis 不可用时服务也能启动
+	Lazy bool
+}
+
+// DefaultConnectOptions 启动时最多重试 3 次, 退避 100ms 起, 最长 2s
+func DefaultConnectOptions() *ConnectOptions {
+	return &ConnectOptions{MaxRetries: 3, MinBackoff: 100 * time.Millisecond, MaxBackoff: 2 * time.Second}
+}
+
+// Connect 创建单机客户端, 非 Lazy 模式下按重试策略 ping, 全部失败时关闭客户端并返回最后一次的错误.
+// 连接建立后 go-redis 会在 redis 恢复后自动重连, 不需要重新创建客户端
+func Connect(opt *redis.Options, clientName string, co *ConnectOptions) (*RedisClient, error) {
+	return Wrap(redis.NewClient(opt), clientName, co)
+}
+
+// ConnectFailover 创建通过 Sentinel 自动主从切换的客户端
+func ConnectFailover(opt *redis.FailoverOptions, clientName string, co *ConnectOptions) (*RedisClient, error) {
+	return Wrap(redis.NewFailoverClient(opt), clientName, co)
+}
+
+// ConnectCluster 创建 Redis Cluster 客户端, 多 key 命令(Del, Unlink 等)的 key 需要在同一个 slot
+func ConnectCluster(opt *redis.ClusterOptions, clientName string, co *ConnectOptions) (*RedisClient, error) {
+	return Wrap(redis.NewClusterClient(opt), clientName, co)
+}
+
+// ConnectRing 创建按 key 一致性哈希分片的 Ring 客户端
+func ConnectRing(opt *redis.RingOptions, clientName string, co *ConnectOptions) (*RedisClient, error) {
+	return Wrap(redis.NewRing(opt), clientName, co)
+}
+
+// Wrap 用已经创建的 go-redis 客户端创建 RedisClient, 连接方式同 Connect
+func Wrap(client Client, clientName string, co *ConnectOptions) (*RedisClient, error) {
+	if co == nil {
+		co = DefaultConnectOptions()
+	}
+
+	c := &RedisClient{Client: client, clientName: clientName, prom: co.Prom, life: lifecycle.NewTracker(clientName), scripts: newScriptRegistry()}
+	if co.Lazy {
+		return c, nil
+	}
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = client.Ping().Err(); err == nil {
+			return c, nil
+		}
+		if attempt >= co.MaxRetries {
+			break
+		}
+		zenlog.Warn("zredis %s ping fail, retry %d: %+v", clientName, attempt+1, err)
+		time.Sleep(co.backoff(attempt))
+	}
+	_ = client.Close()
+	return nil, err
+}
+
+func (co *ConnectOptions) backoff(attempt int) time.Duration {
+	return backoff(attempt, co.MinBackoff, co.MaxBackoff)
+}
+
+// backoff 第 attempt 次(从 0 开始)重试前的等待, 指数退避加 full jitter
+func backoff(attempt int, min, max time.Duration) time.Duration {
+	delay := min
+	for i := 0; i < attempt && (max <= 0 || delay < max); i++ {
+		delay *= 2
+	}
+	if max > 0 && delay > max {
+		delay = max
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(delay)))
+}
+
+// connectOrDegrade 启动时连不上 redis 不退出进程, 记录错误并返回 Lazy 客户端, redis 恢复后自动可用
+func connectOrDegrade(opt *redis.Options, clientName string, prom bool) *RedisClient {
+	co := DefaultConnectOptions()
+	co.Prom = prom
+	c, err := Connect(opt, clientName, co)
+	if err == nil {
+		return c
+	}
+
+	zenlog.Error("zredis %s cannot connect, start degraded: %+v", clientName, err)
+	co.Lazy = true
+	c, _ = Connect(opt, clientName, co)
+	return c
+}
@@ -0,0 +1,52 @@
+package zredis
+
+import (
+	"github.com/go-redis/redis"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"time"
+)
+
+var _ = Describe("Test Connect", func() {
+
+	// 没有 redis 监听的端口
+	unreachable := &redis.Options{Addr: ADDR + ":1", DialTimeout: 100 * time.Millisecond}
+
+	It("Test Connect returns error after retries", func() {
+		start := time.Now()
+		c, err := Connect(unreachable, "test", &ConnectOptions{MaxRetries: 2, MinBackoff: time.Millisecond})
+		Expect(err).Should(HaveOccurred())
+		Expect(c).Should(BeNil())
+		Expect(time.Since(start)).Should(BeNumerically("<", 2*time.Second))
+	})
+
+	It("Test Connect lazy", func() {
+		c, err := Connect(unreachable, "test", &ConnectOptions{Lazy: true})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(c.Get("key").Err()).Should(HaveOccurred())
+		Expect(c.Close()).ShouldNot(HaveOccurred())
+	})
+
+	It("Test NewClient starts degraded", func() {
+		c := NewClient(unreachable, "test")
+		Expect(c).ShouldNot(BeNil())
+		Expect(c.Close()).ShouldNot(HaveOccurred())
+	})
+})
+
+var _ = Describe("Test Ring", func() {
+
+	It("Test wrapped methods on a ring", func() {
+		client, err := ConnectRing(&redis.RingOptions{
+			Addrs: map[string]string{"shard1": ADDR + ":" + PORT},
+			DB:    15,
+		}, "ring", nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		defer client.Close()
+
+		Expect(client.Set("ring-key", "v", time.Minute).Err()).ShouldNot(HaveOccurred())
+		Expect(client.Get("ring-key").Val()).Should(Equal("v"))
+		Expect(client.Keys("ring-*").Val()).Should(ContainElement("ring-key"))
+		Expect(client.Del("ring-key").Val()).Should(Equal(int64(1)))
+	})
+})
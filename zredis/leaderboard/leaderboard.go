@@ -0,0 +1,400 @@
+// Package leaderboard 基于 zredis 有序集合的排行榜, 支持提交模式, 同分按提交时间排名, 赛季归档和容量裁剪
+package leaderboard
+
+import (
+	"github.com/QuRuijie/zenDB/zredis"
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	"strconv"
+	"time"
+)
+
+// Order 排名顺序
+type Order int
+
+const (
+	// Descending 分数高的排名靠前
+	Descending Order = iota
+	// Ascending 分数低的排名靠前, 如通关用时
+	Ascending
+)
+
+// Mode 同一个成员多次提交时如何合并分数
+type Mode int
+
+const (
+	// Best 只保留最好的成绩, 持平时保留更早的提交时间
+	Best Mode = iota
+	// Latest 总是覆盖为最新的成绩
+	Latest
+	// Sum 累加成绩
+	Sum
+)
+
+func (m Mode) String() string {
+	switch m {
+	case Best:
+		return "best"
+	case Latest:
+		return "latest"
+	case Sum:
+		return "sum"
+	}
+	return "Mode(" + strconv.Itoa(int(m)) + ")"
+}
+
+// DefaultTimeBits 提交时间按秒编码, 25 位约 388 天, 分数的绝对值需要小于 2^28
+const DefaultTimeBits = 25
+
+var (
+	ErrNotFound = errors.New("leaderboard: member not found")
+	// ErrScoreOutOfRange 分数超过 score 编码的范围, 见 Options.TimeBits
+	ErrScoreOutOfRange = errors.New("leaderboard: score out of range")
+	// ErrEpochRequired 开启 TieBreak 并且不分赛季时没有设置 Epoch
+	ErrEpochRequired = errors.New("leaderboard: epoch required for tie break without season")
+)
+
+// Options 排行榜的参数
+type Options struct {
+	Order Order
+	Mode  Mode
+	// TieBreak 同分时先提交的排名靠前, 提交时间编码在 score 的低 TimeBits 位,
+	// 分数的绝对值需要小于 2^(53-TimeBits)
+	TieBreak bool
+	// TimeBits 默认 DefaultTimeBits, 超过范围的提交时间按范围的边界编码
+	TimeBits uint
+	// Epoch 没有赛季时提交时间的起点, 有赛季时从赛季开始计算. 不分赛季并开启 TieBreak 时必须设置,
+	// 一般设为排行榜上线的时间, 之后 2^TimeBits 秒内的提交可以区分先后
+	Epoch time.Time
+	// MaxSize 大于 0 时每次提交后只保留前 MaxSize 名
+	MaxSize int64
+	// Season 为 nil 时不分赛季
+	Season Season
+}
+
+// Entry 成员的排名, Rank 从 1 开始
+type Entry struct {
+	Member string
+	Score  int64
+	Rank   int64
+	// SubmittedAt 没有开启 TieBreak 时为零值
+	SubmittedAt time.Time
+}
+
+// Leaderboard 同一个 name 的排行榜在所有进程间共享, 查询默认使用当前赛季, 用 ForSeason 查询其他赛季
+type Leaderboard struct {
+	client *zredis.RedisClient
+	name   string
+	opts   Options
+	scale  int64
+	// season 不为 nil 时固定查询这个赛季
+	season *string
+}
+
+// New 创建排行榜, opts 为 nil 时按分数从高到低排名, 只保留最好成绩.
+// 开启 TieBreak 又不分赛季时必须设置 Epoch, 否则所有提交时间都超出编码范围, 同分无法区分先后
+func New(client *zredis.RedisClient, name string, opts *Options) (*Leaderboard, error) {
+	b := &Leaderboard{client: client, name: name, scale: 1}
+	if opts != nil {
+		b.opts = *opts
+	}
+	if b.opts.TieBreak {
+		if b.opts.Season == nil && b.opts.Epoch.IsZero() {
+			return nil, errors.Wrapf(ErrEpochRequired, "leaderboard %s", name)
+		}
+		if b.opts.TimeBits == 0 {
+			b.opts.TimeBits = DefaultTimeBits
+		}
+		b.scale = 1 << b.opts.TimeBits
+	}
+	return b, nil
+}
+
+// ForSeason 返回查询指定赛季的排行榜, 向它提交成绩仍然写入提交时间所在的赛季
+func (b *Leaderboard) ForSeason(id string) *Leaderboard {
+	view := *b
+	view.season = &id
+	return &view
+}
+
+// SeasonID 当前查询的赛季, 不分赛季时为 ""
+func (b *Leaderboard) SeasonID() string {
+	if b.season != nil {
+		return *b.season
+	}
+	if b.opts.Season == nil {
+		return ""
+	}
+	return b.opts.Season.ID(time.Now())
+}
+
+// Submit 以当前时间提交成绩, 返回合并后的分数, 以及排行榜是否被更新(Best 模式下成绩没有提高时为 false)
+func (b *Leaderboard) Submit(member string, score int64) (int64, bool, error) {
+	return b.SubmitAt(member, score, time.Now())
+}
+
+// SubmitAt 以指定时间提交成绩, 写入 at 所在的赛季, 用于补录数据
+func (b *Leaderboard) SubmitAt(member string, score int64, at time.Time) (int64, bool, error) {
+	seasonID, start, err := b.seasonAt(at)
+	if err != nil {
+		return 0, false, err
+	}
+	limit := int64(1) << (53 - b.opts.TimeBits)
+	if score >= limit || score <= -limit {
+		return 0, false, errors.Wrapf(ErrScoreOutOfRange, "score %d", score)
+	}
+
+	desc := "0"
+	if b.opts.Order == Descending {
+		desc = "1"
+	}
+	vals, err := b.client.RunScript(submitScript, []string{b.key(seasonID), b.seasonsKey()},
+		member, b.opts.Mode.String(), score, b.encodeTime(at, start), b.scale, desc, b.opts.MaxSize, seasonID, limit,
+	).Int64Slice()
+	if err != nil {
+		return 0, false, errors.Wrapf(err, "leaderboard %s submit", b.name)
+	}
+	if vals[0] < 0 {
+		return 0, false, errors.Wrapf(ErrScoreOutOfRange, "score %d", vals[1])
+	}
+	return vals[1], vals[0] == 1, nil
+}
+
+// Get 成员的排名和分数, 不在榜上时返回 ErrNotFound
+func (b *Leaderboard) Get(member string) (*Entry, error) {
+	seasonID := b.SeasonID()
+	key := b.key(seasonID)
+	rank, err := b.client.ZRankX(key, member, b.rev()).Result()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := b.client.ZScore(key, member).Result()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	start, err := b.seasonStart(seasonID)
+	if err != nil {
+		return nil, err
+	}
+	entry := b.decode(redis.Z{Score: encoded, Member: member}, rank, start)
+	return &entry, nil
+}
+
+// Top 前 n 名
+func (b *Leaderboard) Top(n int64) ([]Entry, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	return b.Range(0, n-1)
+}
+
+// Page 第 page 页(从 1 开始), 每页 size 名
+func (b *Leaderboard) Page(page, size int64) ([]Entry, error) {
+	if page < 1 || size <= 0 {
+		return nil, errors.Errorf("leaderboard: invalid page %d size %d", page, size)
+	}
+	start := (page - 1) * size
+	return b.Range(start, start+size-1)
+}
+
+// AroundMe 成员前后各 n 名, 成员在榜首或榜尾时只返回存在的部分, 不在榜上时返回 ErrNotFound
+func (b *Leaderboard) AroundMe(member string, n int64) ([]Entry, error) {
+	rank, err := b.client.ZRankX(b.key(b.SeasonID()), member, b.rev()).Result()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	start := rank - n
+	if start < 0 {
+		start = 0
+	}
+	return b.Range(start, rank+n)
+}
+
+// Range 按排名返回 [start, stop] 的成员, 从 0 开始, 和 ZRANGE 一样支持负数
+func (b *Leaderboard) Range(start, stop int64) ([]Entry, error) {
+	seasonID := b.SeasonID()
+	zs, err := b.client.ZRangeWithScoresX(b.key(seasonID), start, stop, b.rev()).Result()
+	if err != nil {
+		return nil, err
+	}
+	seasonStart, err := b.seasonStart(seasonID)
+	if err != nil {
+		return nil, err
+	}
+	if start < 0 && len(zs) > 0 {
+		count, err := b.client.ZCard(b.key(seasonID)).Result()
+		if err != nil {
+			return nil, err
+		}
+		if start += count; start < 0 {
+			start = 0
+		}
+	}
+	entries := make([]Entry, 0, len(zs))
+	for i, z := range zs {
+		entries = append(entries, b.decode(z, start+int64(i), seasonStart))
+	}
+	return entries, nil
+}
+
+// Count 榜上的成员数
+func (b *Leaderboard) Count() (int64, error) {
+	return b.client.ZCard(b.key(b.SeasonID())).Result()
+}
+
+func (b *Leaderboard) Remove(members ...string) error {
+	if len(members) == 0 {
+		return nil
+	}
+	args := make([]interface{}, len(members))
+	for i, member := range members {
+		args[i] = member
+	}
+	return b.client.ZRem(b.key(b.SeasonID()), args...).Err()
+}
+
+// Trim 只保留前 size 名, 返回移除的成员数
+func (b *Leaderboard) Trim(size int64) (int64, error) {
+	if size < 0 {
+		size = 0
+	}
+	key := b.key(b.SeasonID())
+	if b.opts.Order == Descending {
+		return b.client.ZRemRangeByRank(key, 0, -size-1).Result()
+	}
+	return b.client.ZRemRangeByRank(key, size, -1).Result()
+}
+
+// Clear 删除当前查询的赛季的排行榜, 不归档
+func (b *Leaderboard) Clear() error {
+	seasonID := b.SeasonID()
+	if err := b.client.Del(b.key(seasonID)).Err(); err != nil {
+		return err
+	}
+	if b.opts.Season == nil {
+		return nil
+	}
+	return b.client.SRem(b.seasonsKey(), seasonID).Err()
+}
+
+func (b *Leaderboard) rev() bool {
+	return b.opts.Order == Descending
+}
+
+// key 用 {} 包住 name, Cluster 模式下同一个排行榜的所有 key 在同一个 slot
+func (b *Leaderboard) key(seasonID string) string {
+	if seasonID == "" {
+		return "leaderboard:{" + b.name + "}"
+	}
+	return "leaderboard:{" + b.name + "}:" + seasonID
+}
+
+// seasonsKey 还没有归档的赛季
+func (b *Leaderboard) seasonsKey() string {
+	return "leaderboard:{" + b.name + "}:seasons"
+}
+
+func (b *Leaderboard) seasonAt(t time.Time) (string, time.Time, error) {
+	if b.opts.Season == nil {
+		return "", b.opts.Epoch, nil
+	}
+	id := b.opts.Season.ID(t)
+	start, err := b.seasonStart(id)
+	return id, start, err
+}
+
+// seasonStart 提交时间编码的起点
+func (b *Leaderboard) seasonStart(id string) (time.Time, error) {
+	if b.opts.Season == nil {
+		return b.opts.Epoch, nil
+	}
+	start, err := b.opts.Season.Start(id)
+	if err != nil {
+		return time.Time{}, errors.Wrapf(err, "leaderboard %s season %q", b.name, id)
+	}
+	return start, nil
+}
+
+// encodeTime 降序时越早提交编码越大, 升序时越早提交编码越小, 都是排名靠前
+func (b *Leaderboard) encodeTime(at, start time.Time) int64 {
+	if !b.opts.TieBreak {
+		return 0
+	}
+	elapsed := int64(at.Sub(start) / time.Second)
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	if elapsed > b.scale-1 {
+		elapsed = b.scale - 1
+	}
+	if b.opts.Order == Descending {
+		return b.scale - 1 - elapsed
+	}
+	return elapsed
+}
+
+func (b *Leaderboard) decode(z redis.Z, rank int64, start time.Time) Entry {
+	member, _ := z.Member.(string)
+	encoded := int64(z.Score)
+	score := encoded / b.scale
+	if encoded%b.scale < 0 {
+		score--
+	}
+	entry := Entry{Member: member, Score: score, Rank: rank + 1}
+	if !b.opts.TieBreak {
+		return entry
+	}
+
+	elapsed := encoded - score*b.scale
+	if b.opts.Order == Descending {
+		elapsed = b.scale - 1 - elapsed
+	}
+	entry.SubmittedAt = start.Add(time.Duration(elapsed) * time.Second)
+	return entry
+}
+
+// KEYS: 排行榜, 赛季集合. ARGV: member, mode, score, time, scale, desc, max_size, season, limit.
+// 返回 {是否更新, 合并后的分数}, 分数超过范围时返回 {-1, 分数}
+var submitScript = zredis.NewScript("LeaderboardSubmit", `
+local member = ARGV[1]
+local mode = ARGV[2]
+local score = tonumber(ARGV[3])
+local scale = tonumber(ARGV[5])
+local desc = ARGV[6] == "1"
+local max_size = tonumber(ARGV[7])
+local limit = tonumber(ARGV[9])
+
+local old = redis.call("zscore", KEYS[1], member)
+if old then
+	local old_score = math.floor(tonumber(old) / scale)
+	if mode == "sum" then
+		score = old_score + score
+	elseif mode == "best" and ((desc and score <= old_score) or (not desc and score >= old_score)) then
+		return {0, old_score}
+	end
+end
+if score >= limit or score <= -limit then
+	return {-1, score}
+end
+
+redis.call("zadd", KEYS[1], string.format("%.0f", score * scale + tonumber(ARGV[4])), member)
+if ARGV[8] ~= "" then
+	redis.call("sadd", KEYS[2], ARGV[8])
+end
+if max_size > 0 then
+	if desc then
+		redis.call("zremrangebyrank", KEYS[1], 0, -max_size - 1)
+	else
+		redis.call("zremrangebyrank", KEYS[1], max_size, -1)
+	end
+end
+return {1, score}`)
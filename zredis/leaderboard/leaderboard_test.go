@@ -0,0 +1,161 @@
+package leaderboard
+
+import (
+	"context"
+	"fmt"
+	"github.com/QuRuijie/zenDB/zredis"
+	"github.com/go-redis/redis"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+	"testing"
+	"time"
+)
+
+const (
+	ADDR = "localhost"
+	PORT = "6379"
+)
+
+func TestLeaderboard(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Leaderboard")
+}
+
+type memoryArchiver struct {
+	entries map[string][]Entry
+}
+
+func (a *memoryArchiver) Archive(ctx context.Context, board, season string, entries []Entry) error {
+	a.entries[season] = append(a.entries[season], entries...)
+	return nil
+}
+
+var _ = Describe("Test Season", func() {
+
+	It("Test calendar seasons", func() {
+		t := time.Date(2026, 10, 18, 15, 0, 0, 0, time.UTC) // 周日
+		Expect(Daily(nil).ID(t)).Should(Equal("2026-10-18"))
+		Expect(Weekly(nil).ID(t)).Should(Equal("2026-10-12"))
+		Expect(Monthly(nil).ID(t)).Should(Equal("2026-10"))
+
+		start, err := Weekly(nil).Start("2026-10-12")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(start).Should(Equal(time.Date(2026, 10, 12, 0, 0, 0, 0, time.UTC)))
+	})
+})
+
+var _ = Describe("Test Leaderboard", func() {
+
+	var client *zredis.RedisClient
+	epoch := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	BeforeEach(func() {
+		client = zredis.NewClient(&redis.Options{Addr: ADDR + ":" + PORT, DB: 15}, "test")
+		Expect(client.FlushDB().Err()).ShouldNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(client.Close()).ShouldNot(HaveOccurred())
+	})
+
+	It("Test tie break requires epoch without season", func() {
+		_, err := New(client, "best", &Options{TieBreak: true})
+		Expect(errors.Is(err, ErrEpochRequired)).Should(BeTrue())
+	})
+
+	It("Test best mode with tie break", func() {
+		b, err := New(client, "best", &Options{TieBreak: true, Epoch: epoch})
+		Expect(err).ShouldNot(HaveOccurred())
+		_, _, err = b.SubmitAt("a", 100, epoch.Add(time.Minute))
+		Expect(err).ShouldNot(HaveOccurred())
+		_, _, err = b.SubmitAt("b", 100, epoch.Add(2*time.Minute))
+		Expect(err).ShouldNot(HaveOccurred())
+		_, _, err = b.SubmitAt("c", 200, epoch.Add(3*time.Minute))
+		Expect(err).ShouldNot(HaveOccurred())
+
+		score, updated, err := b.SubmitAt("a", 90, epoch.Add(4*time.Minute))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(updated).Should(BeFalse())
+		Expect(score).Should(Equal(int64(100)))
+
+		top, err := b.Top(3)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(top).Should(Equal([]Entry{
+			{Member: "c", Score: 200, Rank: 1, SubmittedAt: epoch.Add(3 * time.Minute)},
+			{Member: "a", Score: 100, Rank: 2, SubmittedAt: epoch.Add(time.Minute)},
+			{Member: "b", Score: 100, Rank: 3, SubmittedAt: epoch.Add(2 * time.Minute)},
+		}))
+
+		entry, err := b.Get("b")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(entry.Rank).Should(Equal(int64(3)))
+		_, err = b.Get("unknown")
+		Expect(err).Should(Equal(ErrNotFound))
+
+		_, _, err = b.Submit("d", 1<<28)
+		Expect(err).Should(HaveOccurred())
+	})
+
+	It("Test sum and ascending modes", func() {
+		sum, err := New(client, "sum", &Options{Mode: Sum})
+		Expect(err).ShouldNot(HaveOccurred())
+		_, _, _ = sum.Submit("a", 10)
+		score, _, err := sum.Submit("a", -3)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(score).Should(Equal(int64(7)))
+
+		asc, err := New(client, "asc", &Options{Order: Ascending, Mode: Latest})
+		Expect(err).ShouldNot(HaveOccurred())
+		_, _, _ = asc.Submit("a", 30)
+		_, _, _ = asc.Submit("b", 20)
+		_, _, _ = asc.Submit("a", 10)
+		top, err := asc.Top(2)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(top[0].Member).Should(Equal("a"))
+		Expect(top[0].Score).Should(Equal(int64(10)))
+	})
+
+	It("Test around me, paging and trimming", func() {
+		b, err := New(client, "page", &Options{MaxSize: 8})
+		Expect(err).ShouldNot(HaveOccurred())
+		for i := 1; i <= 10; i++ {
+			_, _, err := b.Submit(fmt.Sprintf("m%d", i), int64(i))
+			Expect(err).ShouldNot(HaveOccurred())
+		}
+		Expect(b.Count()).Should(Equal(int64(8)))
+
+		around, err := b.AroundMe("m9", 2)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(around).Should(HaveLen(4))
+		Expect(around[0].Member).Should(Equal("m10"))
+		Expect(around[1].Rank).Should(Equal(int64(2)))
+
+		page, err := b.Page(2, 3)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(page[0].Member).Should(Equal("m7"))
+		Expect(page[0].Rank).Should(Equal(int64(4)))
+
+		removed, err := b.Trim(5)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(removed).Should(Equal(int64(3)))
+	})
+
+	It("Test archive finished seasons", func() {
+		b, err := New(client, "season", &Options{TieBreak: true, Season: Daily(nil)})
+		Expect(err).ShouldNot(HaveOccurred())
+		yesterday := time.Now().Add(-24 * time.Hour)
+		_, _, _ = b.SubmitAt("a", 1, yesterday)
+		_, _, _ = b.SubmitAt("b", 2, yesterday)
+		_, _, _ = b.Submit("c", 3)
+
+		archiver := &memoryArchiver{entries: map[string][]Entry{}}
+		Expect(b.ArchiveFinished(context.Background(), archiver)).ShouldNot(HaveOccurred())
+		archived := archiver.entries[Daily(nil).ID(yesterday)]
+		Expect(archived).Should(HaveLen(2))
+		Expect(archived[0].Member).Should(Equal("b"))
+
+		Expect(b.ForSeason(Daily(nil).ID(yesterday)).Count()).Should(BeZero())
+		Expect(b.Count()).Should(Equal(int64(1)))
+	})
+})
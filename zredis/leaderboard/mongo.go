@@ -0,0 +1,51 @@
+package leaderboard
+
+import (
+	"context"
+	"github.com/QuRuijie/zenDB/zmgo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"time"
+)
+
+// ArchivedEntry MongoArchiver 保存的文档, 每个成员一条, _id 为 board:season:member
+type ArchivedEntry struct {
+	ID          string    `bson:"_id"`
+	Board       string    `bson:"board"`
+	Season      string    `bson:"season"`
+	Member      string    `bson:"member"`
+	Score       int64     `bson:"score"`
+	Rank        int64     `bson:"rank"`
+	SubmittedAt time.Time `bson:"submittedAt,omitempty"`
+	ArchivedAt  time.Time `bson:"archivedAt"`
+}
+
+// MongoArchiver 把赛季归档到 mongo, 按 _id 覆盖写入, 重试不会产生重复文档.
+// Client 为 nil 时通过 zmgo.GetClient(DbName) 获取客户端
+type MongoArchiver struct {
+	Client   *zmgo.MongoClient
+	DbName   string
+	CollName string
+}
+
+func (a *MongoArchiver) Archive(ctx context.Context, board, season string, entries []Entry) error {
+	now := time.Now()
+	models := make([]mongo.WriteModel, 0, len(entries))
+	for _, e := range entries {
+		doc := ArchivedEntry{
+			ID:          board + ":" + season + ":" + e.Member,
+			Board:       board,
+			Season:      season,
+			Member:      e.Member,
+			Score:       e.Score,
+			Rank:        e.Rank,
+			SubmittedAt: e.SubmittedAt,
+			ArchivedAt:  now,
+		}
+		models = append(models, mongo.NewReplaceOneModel().SetFilter(bson.M{"_id": doc.ID}).SetReplacement(doc).SetUpsert(true))
+	}
+	if a.Client == nil {
+		return zmgo.BulkWriteCtx(ctx, a.DbName, a.CollName, models)
+	}
+	return a.Client.BulkWriteCtx(ctx, a.DbName, a.CollName, models)
+}
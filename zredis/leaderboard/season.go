@@ -0,0 +1,155 @@
+package leaderboard
+
+import (
+	"context"
+	"github.com/QuRuijie/zenDB/zredis"
+	"github.com/Zentertain/zenlog"
+	"github.com/pkg/errors"
+	"time"
+)
+
+// ArchiveBatch 归档时每次从 redis 读取并交给 Archiver 的成员数
+const ArchiveBatch = 1000
+
+// Season 按时间划分赛季, 每个赛季是一个独立的有序集合
+type Season interface {
+	// ID 时间 t 所在的赛季
+	ID(t time.Time) string
+	// Start 赛季开始的时间, 同分排名的提交时间从这里开始编码
+	Start(id string) (time.Time, error)
+}
+
+// calendarSeason 按自然日/周/月划分, id 是赛季开始的日期
+type calendarSeason struct {
+	loc      *time.Location
+	layout   string
+	truncate func(t time.Time) time.Time
+}
+
+func (s calendarSeason) ID(t time.Time) string {
+	return s.truncate(t.In(s.loc)).Format(s.layout)
+}
+
+func (s calendarSeason) Start(id string) (time.Time, error) {
+	return time.ParseInLocation(s.layout, id, s.loc)
+}
+
+// Daily 每天一个赛季, id 如 2026-10-18, loc 为 nil 时使用 UTC
+func Daily(loc *time.Location) Season {
+	return calendarSeason{loc: locOrUTC(loc), layout: "2006-01-02", truncate: func(t time.Time) time.Time {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	}}
+}
+
+// Weekly 每周一开始一个赛季, id 是周一的日期
+func Weekly(loc *time.Location) Season {
+	return calendarSeason{loc: locOrUTC(loc), layout: "2006-01-02", truncate: func(t time.Time) time.Time {
+		offset := (int(t.Weekday()) + 6) % 7
+		return time.Date(t.Year(), t.Month(), t.Day()-offset, 0, 0, 0, 0, t.Location())
+	}}
+}
+
+// Monthly 每月一个赛季, id 如 2026-10
+func Monthly(loc *time.Location) Season {
+	return calendarSeason{loc: locOrUTC(loc), layout: "2006-01", truncate: func(t time.Time) time.Time {
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	}}
+}
+
+func locOrUTC(loc *time.Location) *time.Location {
+	if loc == nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// Archiver 保存结束的赛季, 同一个赛季会按排名分批调用, 失败后会从头重试, 需要幂等
+type Archiver interface {
+	Archive(ctx context.Context, board, season string, entries []Entry) error
+}
+
+// Archive 把赛季的全部成员交给 archiver, 成功后删除赛季的排行榜.
+// 多个进程同时归档同一个赛季时只有一个执行, 其他的直接返回
+func (b *Leaderboard) Archive(ctx context.Context, archiver Archiver, seasonID string) error {
+	lock, err := b.client.TryLock(b.key(seasonID)+":archive", nil)
+	if errors.Is(err, zredis.ErrLockNotObtained) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer func() { _ = lock.Unlock() }()
+
+	view := b.ForSeason(seasonID)
+	for start := int64(0); ; start += ArchiveBatch {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		select {
+		case <-lock.Lost():
+			return errors.Errorf("leaderboard %s season %s: archive lock lost", b.name, seasonID)
+		default:
+		}
+
+		entries, err := view.Range(start, start+ArchiveBatch-1)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			break
+		}
+		if err = archiver.Archive(ctx, b.name, seasonID, entries); err != nil {
+			return errors.Wrapf(err, "leaderboard %s archive season %s", b.name, seasonID)
+		}
+		if len(entries) < ArchiveBatch {
+			break
+		}
+	}
+	return view.Clear()
+}
+
+// ArchiveFinished 归档当前赛季之前所有还没有归档的赛季
+func (b *Leaderboard) ArchiveFinished(ctx context.Context, archiver Archiver) error {
+	if b.opts.Season == nil {
+		return nil
+	}
+	seasons, err := b.client.SMembers(b.seasonsKey()).Result()
+	if err != nil {
+		return err
+	}
+	current := b.opts.Season.ID(time.Now())
+	for _, id := range seasons {
+		if id == current {
+			continue
+		}
+		if err = b.Archive(ctx, archiver, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StartArchiver 每隔 interval 调用 ArchiveFinished, 赛季结束后自动归档并重置排行榜, 返回的 stop 停止后台任务
+func (b *Leaderboard) StartArchiver(archiver Archiver, interval time.Duration) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := b.ArchiveFinished(ctx, archiver); err != nil && ctx.Err() == nil {
+				zenlog.Error("leaderboard %s archive fail: %+v", b.name, err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
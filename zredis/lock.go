@@ -0,0 +1,239 @@
+package zredis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrLockNotObtained 锁被其他持有者占用
+	ErrLockNotObtained = errors.New("zredis: lock not obtained")
+	// ErrLockNotHeld 锁已经过期或被其他持有者获取, 当前持有者不能再释放或续期
+	ErrLockNotHeld = errors.New("zredis: lock not held")
+)
+
+var (
+	// 只有 value 等于持有者的 token 时才删除, 避免删除过期后被别人获取的锁
+	unlockScript = NewScript("LockRelease", `
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0`)
+	refreshScript = NewScript("LockRefresh", `
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("pexpire", KEYS[1], ARGV[2])
+end
+return 0`)
+)
+
+// LockOptions 获取锁的参数, 零值字段使用 DefaultLockOptions 中的值
+type LockOptions struct {
+	// TTL 锁的租期, 持有者崩溃时锁最多 TTL 后自动释放
+	TTL time.Duration
+	// RenewInterval 看门狗续期的间隔, 默认 TTL/3, 小于 0 时不自动续期
+	RenewInterval time.Duration
+	// MinBackoff 阻塞获取时第一次重试前的最长等待, 之后每次翻倍, 不超过 MaxBackoff
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// DefaultLockOptions 租期 30s, 每 10s 续期, 重试退避 10ms 起, 最长 500ms
+func DefaultLockOptions() *LockOptions {
+	return &LockOptions{TTL: 30 * time.Second, MinBackoff: 10 * time.Millisecond, MaxBackoff: 500 * time.Millisecond}
+}
+
+func (o *LockOptions) withDefaults() LockOptions {
+	d := DefaultLockOptions()
+	if o == nil {
+		o = d
+	}
+	opts := *o
+	if opts.TTL <= 0 {
+		opts.TTL = d.TTL
+	}
+	if opts.RenewInterval == 0 {
+		opts.RenewInterval = opts.TTL / 3
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = d.MinBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = d.MaxBackoff
+	}
+	return opts
+}
+
+// Lock 分布式锁, value 是持有者随机生成的 token, 释放和续期时检查 token, 只影响自己持有的锁.
+// 持有期间看门狗按 RenewInterval 续期, 发现锁已经不属于自己时关闭 Lost()
+type Lock struct {
+	client *RedisClient
+	key    string
+	token  string
+	ttl    time.Duration
+
+	lost     chan struct{}
+	lostOnce sync.Once
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// TryLock 尝试获取一次锁, 被占用时返回 ErrLockNotObtained
+func (c *RedisClient) TryLock(key string, opts *LockOptions) (*Lock, error) {
+	o := opts.withDefaults()
+	token, err := newLockToken()
+	if err != nil {
+		return nil, err
+	}
+	ok, err := c.SetNX(key, token, o.TTL).Result()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrLockNotObtained
+	}
+	return c.newLock(key, token, o), nil
+}
+
+// AcquireLock 阻塞获取锁, 被占用时按指数退避重试, ctx 结束时返回 ctx.Err()
+func (c *RedisClient) AcquireLock(ctx context.Context, key string, opts *LockOptions) (*Lock, error) {
+	o := opts.withDefaults()
+	token, err := newLockToken()
+	if err != nil {
+		return nil, err
+	}
+	for attempt := 0; ; attempt++ {
+		ok, err := c.SetNX(key, token, o.TTL).Result()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return c.newLock(key, token, o), nil
+		}
+
+		timer := time.NewTimer(backoff(attempt, o.MinBackoff, o.MaxBackoff))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (c *RedisClient) newLock(key, token string, o LockOptions) *Lock {
+	l := &Lock{
+		client: c,
+		key:    key,
+		token:  token,
+		ttl:    o.TTL,
+		lost:   make(chan struct{}),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if o.RenewInterval < 0 {
+		close(l.done)
+		return l
+	}
+	// 客户端 Shutdown 时停止续期, 锁在 TTL 后过期
+	unregister, err := c.life.Register(func() {
+		l.stopWatchdog()
+		l.markLost()
+	})
+	if err != nil {
+		close(l.done)
+		return l
+	}
+	go l.watchdog(o.RenewInterval, unregister)
+	return l
+}
+
+// Key 锁的 key
+func (l *Lock) Key() string {
+	return l.key
+}
+
+// Token 持有者的 token
+func (l *Lock) Token() string {
+	return l.token
+}
+
+// Lost 锁在持有期间丢失(过期后被别人获取, 或续期失败超过 TTL)时关闭, Unlock 后不会关闭
+func (l *Lock) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Refresh 把锁的租期重置为 ttl, 锁已经不属于自己时返回 ErrLockNotHeld
+func (l *Lock) Refresh(ttl time.Duration) error {
+	res, err := l.client.RunScript(refreshScript, []string{l.key}, l.token, ttl.Milliseconds()).Int64()
+	if err != nil {
+		return err
+	}
+	if res == 0 {
+		l.markLost()
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+// Unlock 停止续期并释放锁, 锁已经过期或属于别人时不删除, 返回 ErrLockNotHeld
+func (l *Lock) Unlock() error {
+	l.stopWatchdog()
+	<-l.done
+	res, err := l.client.RunScript(unlockScript, []string{l.key}, l.token).Int64()
+	if err != nil {
+		return err
+	}
+	if res == 0 {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+// watchdog 续期失败(网络错误等)时继续重试, 距离上次成功续期超过 TTL 后认为锁已丢失
+func (l *Lock) watchdog(interval time.Duration, unregister func()) {
+	defer close(l.done)
+	defer unregister()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	renewed := time.Now()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+
+		err := l.Refresh(l.ttl)
+		switch {
+		case err == nil:
+			renewed = time.Now()
+		case errors.Is(err, ErrLockNotHeld):
+			return
+		case time.Since(renewed) >= l.ttl:
+			l.markLost()
+			return
+		}
+	}
+}
+
+func (l *Lock) stopWatchdog() {
+	l.stopOnce.Do(func() { close(l.stop) })
+}
+
+func (l *Lock) markLost() {
+	l.lostOnce.Do(func() { close(l.lost) })
+}
+
+func newLockToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "generate lock token")
+	}
+	return hex.EncodeToString(b), nil
+}
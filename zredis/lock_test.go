@@ -0,0 +1,72 @@
+package zredis
+
+import (
+	"context"
+	"github.com/go-redis/redis"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"time"
+)
+
+var _ = Describe("Test Lock", func() {
+
+	var client *RedisClient
+
+	BeforeEach(func() {
+		client = NewClient(&redis.Options{Addr: ADDR + ":" + PORT, DB: 15}, "test")
+		Expect(client.FlushDB().Err()).ShouldNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(client.Close()).ShouldNot(HaveOccurred())
+	})
+
+	It("Test TryLock and Unlock", func() {
+		lock, err := client.TryLock("lock", nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		_, err = client.TryLock("lock", nil)
+		Expect(err).Should(Equal(ErrLockNotObtained))
+
+		Expect(lock.Unlock()).ShouldNot(HaveOccurred())
+		Expect(client.Exists("lock").Val()).Should(BeZero())
+		Expect(lock.Unlock()).Should(Equal(ErrLockNotHeld))
+	})
+
+	It("Test expired holder cannot release new owner's lock", func() {
+		opts := &LockOptions{TTL: 50 * time.Millisecond, RenewInterval: -1}
+		stale, err := client.TryLock("lock", opts)
+		Expect(err).ShouldNot(HaveOccurred())
+		time.Sleep(100 * time.Millisecond)
+
+		owner, err := client.TryLock("lock", opts)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(stale.Unlock()).Should(Equal(ErrLockNotHeld))
+		Expect(client.Get("lock").Val()).Should(Equal(owner.Token()))
+	})
+
+	It("Test AcquireLock waits for release and respects ctx", func() {
+		lock, err := client.TryLock("lock", nil)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err = client.AcquireLock(ctx, "lock", nil)
+		Expect(err).Should(Equal(context.DeadlineExceeded))
+
+		time.AfterFunc(50*time.Millisecond, func() { _ = lock.Unlock() })
+		next, err := client.AcquireLock(context.Background(), "lock", nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(next.Unlock()).ShouldNot(HaveOccurred())
+	})
+
+	It("Test watchdog renews and reports lost lock", func() {
+		lock, err := client.TryLock("lock", &LockOptions{TTL: 150 * time.Millisecond, RenewInterval: 30 * time.Millisecond})
+		Expect(err).ShouldNot(HaveOccurred())
+		time.Sleep(300 * time.Millisecond)
+		Expect(client.Get("lock").Val()).Should(Equal(lock.Token()))
+
+		Expect(client.Set("lock", "other", 0).Err()).ShouldNot(HaveOccurred())
+		Eventually(lock.Lost()).Should(BeClosed())
+		Expect(lock.Unlock()).Should(Equal(ErrLockNotHeld))
+	})
+})
@@ -0,0 +1,47 @@
+package queue
+
+import (
+	"encoding/json"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Codec 任务内容的编码方式
+type Codec[T any] interface {
+	Encode(v T) ([]byte, error)
+	Decode(data []byte) (T, error)
+}
+
+// JSONCodec 用 encoding/json 编码
+type JSONCodec[T any] struct{}
+
+func (JSONCodec[T]) Encode(v T) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec[T]) Decode(data []byte) (v T, err error) {
+	err = json.Unmarshal(data, &v)
+	return
+}
+
+// BSONCodec 用 bson 编码, T 需要是结构体或 map, 可以和 mongo 文档共用结构体
+type BSONCodec[T any] struct{}
+
+func (BSONCodec[T]) Encode(v T) ([]byte, error) {
+	return bson.Marshal(v)
+}
+
+func (BSONCodec[T]) Decode(data []byte) (v T, err error) {
+	err = bson.Unmarshal(data, &v)
+	return
+}
+
+// StringCodec 不编码, 直接使用字符串
+type StringCodec struct{}
+
+func (StringCodec) Encode(v string) ([]byte, error) {
+	return []byte(v), nil
+}
+
+func (StringCodec) Decode(data []byte) (string, error) {
+	return string(data), nil
+}
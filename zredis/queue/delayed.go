@@ -0,0 +1,177 @@
+package queue
+
+import (
+	"github.com/QuRuijie/zenDB/zredis"
+	"github.com/Zentertain/zenlog"
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PollBatch 每次 Lua 调用最多移动的到期任务数, 避免一次移动太多任务阻塞 redis
+const PollBatch = 100
+
+// Delayed 定时任务, 任务按执行时间保存在有序集合中, 到期后由 poller 移动到 Queue 的队列, 之后按 Queue 的方式可靠处理.
+// 到期任务的移动在一个 Lua 脚本中完成, 多个进程同时 Poll 时每个任务只会进入队列一次
+type Delayed[T any] struct {
+	queue *Queue[T]
+}
+
+// NewDelayed 创建投递到 q 的定时任务
+func NewDelayed[T any](q *Queue[T]) *Delayed[T] {
+	return &Delayed[T]{queue: q}
+}
+
+// ScheduleAt 在 at 时投递任务, 返回任务 id, 投递后 Job.ID 也是这个 id
+func (d *Delayed[T]) ScheduleAt(payload T, at time.Time) (string, error) {
+	id, err := newID()
+	if err != nil {
+		return "", err
+	}
+	return id, d.ScheduleWithID(id, payload, at)
+}
+
+// ScheduleAfter 在 delay 后投递任务
+func (d *Delayed[T]) ScheduleAfter(payload T, delay time.Duration) (string, error) {
+	return d.ScheduleAt(payload, time.Now().Add(delay))
+}
+
+// ScheduleWithID 用指定的 id 安排任务, 同一个 id 还没有投递时替换原来的内容和时间, 用于每个对象只有一个定时任务的场景.
+// id 不能包含 "|"
+func (d *Delayed[T]) ScheduleWithID(id string, payload T, at time.Time) error {
+	if id == "" || strings.Contains(id, "|") {
+		return errors.Errorf("queue %s: invalid job id %q", d.queue.name, id)
+	}
+	// 入队时间记为计划执行的时间, 队列的等待时间指标反映投递的延迟
+	raw, err := d.queue.encode(id, payload, at)
+	if err != nil {
+		return err
+	}
+	return d.queue.client.RunScript(scheduleScript, []string{d.scheduledKey(), d.jobsKey()},
+		id, at.UnixMilli(), raw).Err()
+}
+
+// Cancel 取消还没有投递的任务, 返回 false 表示任务不存在或已经投递
+func (d *Delayed[T]) Cancel(id string) (bool, error) {
+	res, err := d.queue.client.RunScript(cancelScript, []string{d.scheduledKey(), d.jobsKey()}, id).Int64()
+	return res == 1, err
+}
+
+// Reschedule 修改还没有投递的任务的执行时间, 任务的入队时间也改为新的执行时间, 返回 false 表示任务不存在或已经投递
+func (d *Delayed[T]) Reschedule(id string, at time.Time) (bool, error) {
+	res, err := d.queue.client.RunScript(rescheduleScript, []string{d.scheduledKey(), d.jobsKey()}, id, at.UnixMilli()).Int64()
+	return res == 1, err
+}
+
+// When 任务计划执行的时间, 任务不存在或已经投递时返回 false
+func (d *Delayed[T]) When(id string) (time.Time, bool, error) {
+	ms, err := d.queue.client.ZScore(d.scheduledKey(), id).Result()
+	if err == redis.Nil {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return time.UnixMilli(int64(ms)), true, nil
+}
+
+// Count 还没有投递的任务数
+func (d *Delayed[T]) Count() (int64, error) {
+	return d.queue.client.ZCard(d.scheduledKey()).Result()
+}
+
+// Poll 把所有到期的任务移动到队列, 返回移动的任务数
+func (d *Delayed[T]) Poll() (int, error) {
+	moved := 0
+	for {
+		now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+		n, err := d.queue.client.RunScript(pollScript, []string{d.scheduledKey(), d.jobsKey(), d.queue.pendingKey()},
+			now, PollBatch).Int64()
+		if err != nil {
+			return moved, err
+		}
+		moved += int(n)
+		if n < PollBatch {
+			return moved, nil
+		}
+	}
+}
+
+// StartPoller 每隔 interval 调用一次 Poll, 多个进程可以同时运行, 返回的 stop 停止后台任务
+func (d *Delayed[T]) StartPoller(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if _, err := d.Poll(); err != nil {
+				zenlog.Error("queue %s poll delayed jobs fail: %+v", d.queue.name, err)
+			}
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+func (d *Delayed[T]) scheduledKey() string {
+	return d.queue.key("scheduled")
+}
+
+// jobsKey 定时任务的内容, 以 id 为 field
+func (d *Delayed[T]) jobsKey() string {
+	return d.queue.key("scheduled:jobs")
+}
+
+// KEYS: 执行时间, 任务内容. ARGV: id, 执行时间(毫秒), 任务
+var scheduleScript = zredis.NewScript("QueueSchedule", `
+redis.call("zadd", KEYS[1], ARGV[2], ARGV[1])
+redis.call("hset", KEYS[2], ARGV[1], ARGV[3])
+return 1`)
+
+// KEYS: 执行时间, 任务内容. ARGV: id
+var cancelScript = zredis.NewScript("QueueCancel", `
+if redis.call("zrem", KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+redis.call("hdel", KEYS[2], ARGV[1])
+return 1`)
+
+// KEYS: 执行时间, 任务内容. ARGV: id, 执行时间(毫秒). 任务 id|入队时间|内容 中的入队时间替换为新的执行时间
+var rescheduleScript = zredis.NewScript("QueueReschedule", `
+if not redis.call("zscore", KEYS[1], ARGV[1]) then
+	return 0
+end
+redis.call("zadd", KEYS[1], ARGV[2], ARGV[1])
+local raw = redis.call("hget", KEYS[2], ARGV[1])
+if raw then
+	local id, payload = string.match(raw, "^([^|]*)|[^|]*|(.*)$")
+	if id then
+		redis.call("hset", KEYS[2], ARGV[1], id .. "|" .. ARGV[2] .. "|" .. payload)
+	end
+end
+return 1`)
+
+// KEYS: 执行时间, 任务内容, 队列. ARGV: 当前时间(毫秒), 数量.
+// 按执行时间从早到晚加入队列左边, 最早到期的任务最先被取出
+var pollScript = zredis.NewScript("QueuePollDelayed", `
+local ids = redis.call("zrangebyscore", KEYS[1], "-inf", ARGV[1], "limit", 0, tonumber(ARGV[2]))
+for _, id in ipairs(ids) do
+	local raw = redis.call("hget", KEYS[2], id)
+	if raw then
+		redis.call("lpush", KEYS[3], raw)
+	end
+	redis.call("zrem", KEYS[1], id)
+	redis.call("hdel", KEYS[2], id)
+end
+return #ids`)
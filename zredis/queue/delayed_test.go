@@ -0,0 +1,88 @@
+package queue
+
+import (
+	"fmt"
+	"github.com/QuRuijie/zenDB/zredis"
+	"github.com/go-redis/redis"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"sync"
+	"time"
+)
+
+var _ = Describe("Test Delayed", func() {
+
+	var client *zredis.RedisClient
+
+	BeforeEach(func() {
+		client = zredis.NewClient(&redis.Options{Addr: ADDR + ":" + PORT, DB: 15}, "test")
+		Expect(client.FlushDB().Err()).ShouldNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(client.Close()).ShouldNot(HaveOccurred())
+	})
+
+	It("Test schedule, cancel and reschedule", func() {
+		q := New[string](client, "delayed", StringCodec{}, nil)
+		d := NewDelayed(q)
+		due, err := d.ScheduleAt("due", time.Now().Add(-time.Second))
+		Expect(err).ShouldNot(HaveOccurred())
+		later, err := d.ScheduleAfter("later", time.Hour)
+		Expect(err).ShouldNot(HaveOccurred())
+		canceled, err := d.ScheduleAfter("canceled", -time.Second)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Expect(d.Cancel(canceled)).Should(BeTrue())
+		Expect(d.Cancel(canceled)).Should(BeFalse())
+		Expect(d.Poll()).Should(Equal(1))
+		job, err := q.reserve("w1")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(job.ID).Should(Equal(due))
+		Expect(job.Payload).Should(Equal("due"))
+
+		at, ok, err := d.When(later)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(ok).Should(BeTrue())
+		Expect(at).Should(BeTemporally("~", time.Now().Add(time.Hour), time.Second))
+		Expect(d.Reschedule(later, time.Now())).Should(BeTrue())
+		Expect(d.Poll()).Should(Equal(1))
+		job, err = q.reserve("w1")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(job.ID).Should(Equal(later))
+		Expect(job.EnqueuedAt).Should(BeTemporally("~", time.Now(), time.Second))
+		Expect(d.Reschedule(later, time.Now())).Should(BeFalse())
+		Expect(d.Count()).Should(BeZero())
+
+		Expect(d.ScheduleWithID("a|b", "bad", time.Now())).Should(HaveOccurred())
+	})
+
+	It("Test concurrent pollers deliver each job once", func() {
+		q := New[string](client, "concurrent", StringCodec{}, nil)
+		d := NewDelayed(q)
+		for i := 0; i < 2*PollBatch+10; i++ {
+			Expect(d.ScheduleWithID(fmt.Sprintf("job%d", i), "x", time.Now().Add(-time.Second))).ShouldNot(HaveOccurred())
+		}
+
+		var (
+			wg    sync.WaitGroup
+			mu    sync.Mutex
+			moved int
+		)
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer GinkgoRecover()
+				n, err := d.Poll()
+				Expect(err).ShouldNot(HaveOccurred())
+				mu.Lock()
+				moved += n
+				mu.Unlock()
+			}()
+		}
+		wg.Wait()
+		Expect(moved).Should(Equal(2*PollBatch + 10))
+		Expect(client.LLen(q.pendingKey()).Val()).Should(Equal(int64(2*PollBatch + 10)))
+	})
+})
@@ -0,0 +1,378 @@
+// Package queue 基于 zredis 列表的可靠任务队列, 任务在处理完成前保存在 worker 的处理中列表里,
+// worker 崩溃或处理超时的任务由 reaper 放回队列, 超过重试次数的任务进入死信列表. Delayed 在指定时间把任务投递到队列
+package queue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/QuRuijie/zenDB/lifecycle"
+	"github.com/QuRuijie/zenDB/prom"
+	"github.com/QuRuijie/zenDB/zredis"
+	"github.com/Zentertain/zenlog"
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrJobLost 任务已经不在处理中列表, 处理超时被 reaper 放回了队列, 可能已经被其他 worker 处理
+	ErrJobLost = errors.New("queue: job no longer held by worker")
+	// ErrInvalidJob 队列中的数据不是 Enqueue 写入的格式
+	ErrInvalidJob = errors.New("queue: invalid job")
+)
+
+// Options 队列的参数, 零值字段使用 DefaultOptions 中的值
+type Options struct {
+	// VisibilityTimeout 任务取出后需要在这个时间内处理完, 超时后由 reaper 放回队列.
+	// worker 的心跳也以此为有效期, 心跳过期的 worker 的所有任务都会被放回队列
+	VisibilityTimeout time.Duration
+	// MaxRetries 处理失败或超时后最多重新放回队列的次数, 超过后进入死信列表, 小于 0 时不重试
+	MaxRetries int
+	// PollTimeout 队列为空时 BRPOPLPUSH 阻塞等待的时间
+	PollTimeout time.Duration
+	Prom        bool
+}
+
+// DefaultOptions 处理超时 30s, 最多重试 3 次, 阻塞等待 1s
+func DefaultOptions() *Options {
+	return &Options{VisibilityTimeout: 30 * time.Second, MaxRetries: 3, PollTimeout: time.Second}
+}
+
+// Job 从队列中取出的任务, Attempts 是之前失败或超时的次数
+type Job[T any] struct {
+	ID         string
+	Payload    T
+	Attempts   int
+	EnqueuedAt time.Time
+	raw        string
+}
+
+// Handler 处理任务, 返回 nil 时确认任务完成, 返回错误或 panic 时按重试策略放回队列或进入死信列表
+type Handler[T any] func(ctx context.Context, job *Job[T]) error
+
+// Queue 同一个 name 的队列在所有进程间共享, 所有 key 用 {name} 作为 hash tag, 可以在 Cluster 模式下使用
+type Queue[T any] struct {
+	client *zredis.RedisClient
+	name   string
+	codec  Codec[T]
+	opts   Options
+}
+
+// New 创建队列, opts 为 nil 时使用 DefaultOptions
+func New[T any](client *zredis.RedisClient, name string, codec Codec[T], opts *Options) *Queue[T] {
+	d := DefaultOptions()
+	o := *d
+	if opts != nil {
+		o = *opts
+	}
+	if o.VisibilityTimeout <= 0 {
+		o.VisibilityTimeout = d.VisibilityTimeout
+	}
+	if o.MaxRetries == 0 {
+		o.MaxRetries = d.MaxRetries
+	} else if o.MaxRetries < 0 {
+		o.MaxRetries = 0
+	}
+	if o.PollTimeout <= 0 {
+		o.PollTimeout = d.PollTimeout
+	}
+	return &Queue[T]{client: client, name: name, codec: codec, opts: o}
+}
+
+func (q *Queue[T]) Name() string {
+	return q.name
+}
+
+// Enqueue 把任务加入队列尾部, 返回任务 id
+func (q *Queue[T]) Enqueue(payload T) (string, error) {
+	id, err := newID()
+	if err != nil {
+		return "", err
+	}
+	raw, err := q.encode(id, payload, time.Now())
+	if err != nil {
+		return "", err
+	}
+	if err = q.client.LPush(q.pendingKey(), raw).Err(); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// Consume 以 worker 的身份阻塞处理任务, 直到 ctx 结束或客户端关闭. 同一个 worker 同时只能有一个 Consume.
+// ctx 结束时等待正在处理的任务完成后返回 nil
+func (q *Queue[T]) Consume(ctx context.Context, worker string, handler Handler[T]) error {
+	stop := q.heartbeat(worker)
+	defer func() {
+		stop()
+		q.leave(worker)
+	}()
+
+	for attempt := 0; ctx.Err() == nil; {
+		job, err := q.reserve(worker)
+		if err == redis.Nil {
+			continue
+		}
+		if errors.Is(err, lifecycle.ErrClosed) {
+			return err
+		}
+		if err != nil {
+			zenlog.Error("queue %s worker %s reserve fail: %+v", q.name, worker, err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(q.opts.PollTimeout << attempt):
+			}
+			if attempt < 5 {
+				attempt++
+			}
+			continue
+		}
+		attempt = 0
+		if job != nil {
+			q.process(ctx, worker, job, handler)
+		}
+	}
+	return nil
+}
+
+// reserve 把任务从队列原子地移动到 worker 的处理中列表, 并记录处理超时时间.
+// 记录失败时把任务放回队列, 放回也失败时由 reaper 补上处理超时时间. 队列中无法解析的任务直接进入死信列表, 返回 nil
+func (q *Queue[T]) reserve(worker string) (*Job[T], error) {
+	raw, err := q.client.BRPopLPush(q.pendingKey(), q.processingKey(worker), q.opts.PollTimeout).Result()
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(q.opts.VisibilityTimeout)
+	if err = q.client.ZAdd(q.leasesKey(worker), redis.Z{Score: float64(deadline.UnixMilli()), Member: raw}).Err(); err != nil {
+		if uerr := q.client.RunScript(unreserveScript, []string{q.processingKey(worker), q.pendingKey()}, raw).Err(); uerr != nil {
+			zenlog.Error("queue %s worker %s return job to queue fail: %+v", q.name, worker, uerr)
+		}
+		return nil, err
+	}
+
+	job, err := q.decode(raw)
+	if err != nil {
+		zenlog.Error("queue %s drop invalid job to dead letters: %+v", q.name, err)
+		_, err = q.retry(worker, raw, job.ID, 0)
+		return nil, err
+	}
+	attempts, err := q.client.HGet(q.attemptsKey(), job.ID).Int()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	job.Attempts = attempts
+	return job, nil
+}
+
+func (q *Queue[T]) process(ctx context.Context, worker string, job *Job[T], handler Handler[T]) {
+	err := q.handle(ctx, job, handler)
+	if err == nil {
+		err = q.ack(worker, job)
+	} else {
+		zenlog.Warn("queue %s job %s attempt %d fail: %+v", q.name, job.ID, job.Attempts+1, err)
+		err = q.nack(worker, job)
+	}
+	if err != nil && !errors.Is(err, ErrJobLost) {
+		zenlog.Error("queue %s job %s settle fail: %+v", q.name, job.ID, err)
+	}
+}
+
+func (q *Queue[T]) handle(ctx context.Context, job *Job[T], handler Handler[T]) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("panic: %v", r)
+		}
+	}()
+	return handler(ctx, job)
+}
+
+// ack 从处理中列表删除任务, 任务已经被 reaper 放回队列时返回 ErrJobLost
+func (q *Queue[T]) ack(worker string, job *Job[T]) error {
+	res, err := q.client.RunScript(ackScript, []string{q.processingKey(worker), q.leasesKey(worker), q.attemptsKey()},
+		job.raw, job.ID).Int64()
+	if err != nil {
+		return err
+	}
+	if res == 0 {
+		return ErrJobLost
+	}
+	q.promMonitor("Acked")
+	return nil
+}
+
+func (q *Queue[T]) nack(worker string, job *Job[T]) error {
+	_, err := q.retry(worker, job.raw, job.ID, q.opts.MaxRetries)
+	return err
+}
+
+// retry 把处理中的任务放回队列, 超过 maxRetries 时放入死信列表, 返回 true 表示进入了死信列表
+func (q *Queue[T]) retry(worker, raw, id string, maxRetries int) (bool, error) {
+	res, err := q.client.RunScript(retryScript,
+		[]string{q.processingKey(worker), q.leasesKey(worker), q.pendingKey(), q.deadKey(), q.attemptsKey()},
+		raw, id, maxRetries).Int64()
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case res < 0:
+		return false, ErrJobLost
+	case res == 0:
+		q.promMonitor("Dead")
+		return true, nil
+	}
+	q.promMonitor("Retried")
+	return false, nil
+}
+
+// heartbeat 定期刷新 worker 的心跳, 心跳过期后 reaper 认为 worker 已经崩溃.
+// 每次心跳都重新注册 worker, 避免 reaper 注销同名的旧 worker 时把新 worker 一起注销
+func (q *Queue[T]) heartbeat(worker string) (stop func()) {
+	beat := func() {
+		err := q.client.Set(q.heartbeatKey(worker), "1", q.opts.VisibilityTimeout).Err()
+		if err == nil {
+			err = q.client.SAdd(q.workersKey(), worker).Err()
+		}
+		if err != nil {
+			zenlog.Warn("queue %s worker %s heartbeat fail: %+v", q.name, worker, err)
+		}
+	}
+	beat()
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(q.opts.VisibilityTimeout / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				beat()
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// leave 正常退出时删除心跳, 处理中列表已经为空时注销 worker
+func (q *Queue[T]) leave(worker string) {
+	if err := q.client.Del(q.heartbeatKey(worker)).Err(); err == nil {
+		_ = q.forget(worker)
+	}
+}
+
+// encode 任务在列表中保存为 id|入队时间(毫秒)|内容
+func (q *Queue[T]) encode(id string, payload T, enqueuedAt time.Time) (string, error) {
+	data, err := q.codec.Encode(payload)
+	if err != nil {
+		return "", errors.Wrapf(err, "queue %s encode", q.name)
+	}
+	return id + "|" + strconv.FormatInt(enqueuedAt.UnixMilli(), 10) + "|" + string(data), nil
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "queue: generate job id")
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// decode 解析失败时返回的 Job 仍然带有能解析出的 ID
+func (q *Queue[T]) decode(raw string) (*Job[T], error) {
+	parts := strings.SplitN(raw, "|", 3)
+	job := &Job[T]{ID: parts[0], raw: raw}
+	if len(parts) != 3 {
+		return job, errors.Wrapf(ErrInvalidJob, "%.64q", raw)
+	}
+	ms, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return job, errors.Wrapf(ErrInvalidJob, "%.64q", raw)
+	}
+	job.EnqueuedAt = time.UnixMilli(ms)
+	if job.Payload, err = q.codec.Decode([]byte(parts[2])); err != nil {
+		return job, errors.Wrapf(err, "queue %s decode job %s", q.name, job.ID)
+	}
+	return job, nil
+}
+
+func (q *Queue[T]) promMonitor(result string) {
+	if q.opts.Prom {
+		prom.SetQueueJobMetrics(q.name, result)
+	}
+}
+
+func (q *Queue[T]) key(suffix string) string {
+	return fmt.Sprintf("queue:{%s}:%s", q.name, suffix)
+}
+
+func (q *Queue[T]) pendingKey() string {
+	return q.key("pending")
+}
+
+func (q *Queue[T]) deadKey() string {
+	return q.key("dead")
+}
+
+func (q *Queue[T]) attemptsKey() string {
+	return q.key("attempts")
+}
+
+func (q *Queue[T]) workersKey() string {
+	return q.key("workers")
+}
+
+func (q *Queue[T]) processingKey(worker string) string {
+	return q.key("processing:" + worker)
+}
+
+func (q *Queue[T]) leasesKey(worker string) string {
+	return q.key("leases:" + worker)
+}
+
+func (q *Queue[T]) heartbeatKey(worker string) string {
+	return q.key("heartbeat:" + worker)
+}
+
+// KEYS: 处理中列表, 队列. ARGV: 任务. 放回队列的出队一端, 下一个被取出, 不计入重试次数
+var unreserveScript = zredis.NewScript("QueueUnreserve", `
+if redis.call("lrem", KEYS[1], 1, ARGV[1]) == 0 then
+	return 0
+end
+redis.call("rpush", KEYS[2], ARGV[1])
+return 1`)
+
+// KEYS: 处理中列表, 处理超时时间, 重试次数. ARGV: 任务, id
+var ackScript = zredis.NewScript("QueueAck", `
+if redis.call("lrem", KEYS[1], 1, ARGV[1]) == 0 then
+	return 0
+end
+redis.call("zrem", KEYS[2], ARGV[1])
+redis.call("hdel", KEYS[3], ARGV[2])
+return 1`)
+
+// KEYS: 处理中列表, 处理超时时间, 队列, 死信列表, 重试次数. ARGV: 任务, id, 最大重试次数.
+// 返回 -1 任务已经不在处理中列表, 0 进入死信列表, 其他为已经重试的次数.
+// 进入死信列表的任务保存为 重试次数|任务, 同时删除重试次数, 重试次数只保存在队列和处理中的任务
+var retryScript = zredis.NewScript("QueueRetry", `
+if redis.call("lrem", KEYS[1], 1, ARGV[1]) == 0 then
+	return -1
+end
+redis.call("zrem", KEYS[2], ARGV[1])
+local attempts = redis.call("hincrby", KEYS[5], ARGV[2], 1)
+if attempts > tonumber(ARGV[3]) then
+	redis.call("hdel", KEYS[5], ARGV[2])
+	redis.call("lpush", KEYS[4], attempts .. "|" .. ARGV[1])
+	return 0
+end
+redis.call("lpush", KEYS[3], ARGV[1])
+return attempts`)
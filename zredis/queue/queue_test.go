@@ -0,0 +1,193 @@
+package queue
+
+import (
+	"context"
+	"github.com/QuRuijie/zenDB/zredis"
+	"github.com/go-redis/redis"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+	"testing"
+	"time"
+)
+
+const (
+	ADDR = "localhost"
+	PORT = "6379"
+)
+
+func TestQueue(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Queue")
+}
+
+type task struct {
+	Name  string `json:"name" bson:"name"`
+	Count int    `json:"count" bson:"count"`
+}
+
+var _ = Describe("Test Codec", func() {
+
+	It("Test JSON and BSON codecs", func() {
+		for _, codec := range []Codec[task]{JSONCodec[task]{}, BSONCodec[task]{}} {
+			data, err := codec.Encode(task{Name: "a", Count: 1})
+			Expect(err).ShouldNot(HaveOccurred())
+			v, err := codec.Decode(data)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(v).Should(Equal(task{Name: "a", Count: 1}))
+		}
+	})
+})
+
+var _ = Describe("Test Queue", func() {
+
+	var client *zredis.RedisClient
+
+	BeforeEach(func() {
+		client = zredis.NewClient(&redis.Options{Addr: ADDR + ":" + PORT, DB: 15}, "test")
+		Expect(client.FlushDB().Err()).ShouldNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(client.Close()).ShouldNot(HaveOccurred())
+	})
+
+	It("Test consume and ack", func() {
+		q := New[task](client, "ack", JSONCodec[task]{}, nil)
+		id, err := q.Enqueue(task{Name: "a", Count: 1})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		got := make(chan *Job[task], 1)
+		done := make(chan error)
+		go func() {
+			done <- q.Consume(ctx, "w1", func(ctx context.Context, job *Job[task]) error {
+				got <- job
+				cancel()
+				return nil
+			})
+		}()
+
+		var job *Job[task]
+		Eventually(got, 2*time.Second).Should(Receive(&job))
+		Expect(job.ID).Should(Equal(id))
+		Expect(job.Payload).Should(Equal(task{Name: "a", Count: 1}))
+		Eventually(done, 3*time.Second).Should(Receive(BeNil()))
+
+		stats, err := q.Stats()
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(*stats).Should(Equal(Stats{}))
+	})
+
+	It("Test retries and dead letters", func() {
+		q := New[string](client, "retry", StringCodec{}, &Options{MaxRetries: 1})
+		_, err := q.Enqueue("job")
+		Expect(err).ShouldNot(HaveOccurred())
+
+		for i := 0; i < 2; i++ {
+			job, err := q.reserve("w1")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(job.Attempts).Should(Equal(i))
+			q.process(context.Background(), "w1", job, func(ctx context.Context, job *Job[string]) error {
+				return errors.New("fail")
+			})
+		}
+
+		stats, err := q.Stats()
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(stats.Pending).Should(BeZero())
+		Expect(stats.Dead).Should(Equal(int64(1)))
+		dead, err := q.DeadLetters(10)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(dead[0].Payload).Should(Equal("job"))
+		Expect(dead[0].Attempts).Should(Equal(2))
+		Expect(client.HLen(q.attemptsKey()).Val()).Should(BeZero())
+
+		Expect(q.RequeueDead(10)).Should(Equal(int64(1)))
+		job, err := q.reserve("w1")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(job.Attempts).Should(BeZero())
+	})
+
+	It("Test reaper requeues jobs of crashed workers and timed out jobs", func() {
+		q := New[string](client, "reap", StringCodec{}, &Options{VisibilityTimeout: 50 * time.Millisecond})
+		_, _ = q.Enqueue("crashed")
+		_, _ = q.Enqueue("slow")
+
+		// w1 取出任务后崩溃, 没有心跳
+		Expect(client.SAdd(q.workersKey(), "w1").Err()).ShouldNot(HaveOccurred())
+		crashed, err := q.reserve("w1")
+		Expect(err).ShouldNot(HaveOccurred())
+
+		// w2 还活着, 但处理超时
+		Expect(client.SAdd(q.workersKey(), "w2").Err()).ShouldNot(HaveOccurred())
+		Expect(client.Set(q.heartbeatKey("w2"), "1", time.Minute).Err()).ShouldNot(HaveOccurred())
+		slow, err := q.reserve("w2")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(q.Reap()).Should(Equal(1))
+		time.Sleep(100 * time.Millisecond)
+		Expect(q.Reap()).Should(Equal(1))
+
+		Expect(client.SIsMember(q.workersKey(), "w1").Val()).Should(BeFalse())
+		Expect(q.ack("w2", slow)).Should(Equal(ErrJobLost))
+		Expect(q.ack("w1", crashed)).Should(Equal(ErrJobLost))
+		stats, err := q.Stats()
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(stats.Pending).Should(Equal(int64(2)))
+		Expect(stats.Processing).Should(BeZero())
+	})
+
+	It("Test job without lease is not stuck in processing", func() {
+		q := New[string](client, "lease", StringCodec{}, &Options{VisibilityTimeout: 50 * time.Millisecond})
+		_, _ = q.Enqueue("job")
+
+		// 记录处理超时时间失败时, 任务放回队列
+		Expect(client.Set(q.leasesKey("w1"), "broken", 0).Err()).ShouldNot(HaveOccurred())
+		_, err := q.reserve("w1")
+		Expect(err).Should(HaveOccurred())
+		stats, err := q.Stats()
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(stats.Pending).Should(Equal(int64(1)))
+		Expect(stats.Processing).Should(BeZero())
+
+		// 放回也失败时, reaper 补上处理超时时间, 超时后放回队列
+		Expect(client.SAdd(q.workersKey(), "w2").Err()).ShouldNot(HaveOccurred())
+		Expect(client.Set(q.heartbeatKey("w2"), "1", time.Minute).Err()).ShouldNot(HaveOccurred())
+		Expect(client.RPopLPush(q.pendingKey(), q.processingKey("w2")).Err()).ShouldNot(HaveOccurred())
+		Expect(q.Reap()).Should(Equal(0))
+		Expect(client.ZCard(q.leasesKey("w2")).Val()).Should(Equal(int64(1)))
+		time.Sleep(100 * time.Millisecond)
+		Expect(q.Reap()).Should(Equal(1))
+		stats, err = q.Stats()
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(stats.Pending).Should(Equal(int64(1)))
+		Expect(stats.Processing).Should(BeZero())
+	})
+
+	It("Test reaper keeps restarted worker with the same name", func() {
+		q := New[string](client, "restart", StringCodec{}, nil)
+		_, _ = q.Enqueue("job")
+
+		// 崩溃的 w1 还有任务在处理中列表时不会被注销
+		Expect(client.SAdd(q.workersKey(), "w1").Err()).ShouldNot(HaveOccurred())
+		job, err := q.reserve("w1")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(q.forget("w1")).ShouldNot(HaveOccurred())
+		Expect(client.SIsMember(q.workersKey(), "w1").Val()).Should(BeTrue())
+
+		// reaper 放回任务后, 同名的 w1 在注销前重启并重新取出任务
+		_, err = q.retry("w1", job.raw, job.ID, q.opts.MaxRetries)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(client.Set(q.heartbeatKey("w1"), "1", time.Minute).Err()).ShouldNot(HaveOccurred())
+		job, err = q.reserve("w1")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(q.forget("w1")).ShouldNot(HaveOccurred())
+		Expect(client.SIsMember(q.workersKey(), "w1").Val()).Should(BeTrue())
+		Expect(client.Exists(q.leasesKey("w1")).Val()).Should(Equal(int64(1)))
+
+		// 重启的 w1 的任务仍然可以正常确认, 退出后注销
+		Expect(q.ack("w1", job)).ShouldNot(HaveOccurred())
+		q.leave("w1")
+		Expect(client.SIsMember(q.workersKey(), "w1").Val()).Should(BeFalse())
+	})
+})
@@ -0,0 +1,208 @@
+package queue
+
+import (
+	"github.com/QuRuijie/zenDB/prom"
+	"github.com/QuRuijie/zenDB/zredis"
+	"github.com/Zentertain/zenlog"
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stats 队列的状态, OldestAge 是队列中最早的任务已经等待的时间
+type Stats struct {
+	Pending    int64
+	Processing int64
+	Dead       int64
+	OldestAge  time.Duration
+}
+
+// Reap 把心跳过期的 worker 的所有任务和处理超时的任务放回队列(计入重试次数), 返回处理的任务数.
+// reserve 没能记录处理超时时间的任务从这次 Reap 开始计时, 超时后同样放回队列.
+// 可以在多个进程同时调用, 同一个任务只会被放回一次
+func (q *Queue[T]) Reap() (int, error) {
+	workers, err := q.client.SMembers(q.workersKey()).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	reaped := 0
+	for _, worker := range workers {
+		alive, err := q.client.Exists(q.heartbeatKey(worker)).Result()
+		if err != nil {
+			return reaped, err
+		}
+		var raws []string
+		if alive == 0 {
+			raws, err = q.client.LRange(q.processingKey(worker), 0, -1).Result()
+		} else {
+			deadline := time.Now().Add(q.opts.VisibilityTimeout).UnixMilli()
+			err = q.client.RunScript(leaseOrphansScript, []string{q.processingKey(worker), q.leasesKey(worker)}, deadline).Err()
+			if err != nil {
+				return reaped, err
+			}
+			now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+			raws, err = q.client.ZRangeByScore(q.leasesKey(worker), redis.ZRangeBy{Min: "-inf", Max: now}).Result()
+		}
+		if err != nil {
+			return reaped, err
+		}
+
+		for _, raw := range raws {
+			id := strings.SplitN(raw, "|", 2)[0]
+			_, err = q.retry(worker, raw, id, q.opts.MaxRetries)
+			if errors.Is(err, ErrJobLost) {
+				continue
+			}
+			if err != nil {
+				return reaped, err
+			}
+			reaped++
+		}
+		if alive == 0 {
+			if err = q.forget(worker); err != nil {
+				return reaped, err
+			}
+		}
+	}
+	return reaped, nil
+}
+
+// forget 注销已经崩溃并且任务都已经放回队列的 worker. 检查和注销在一个脚本中完成,
+// 同名的 worker 重启后已经有心跳或正在处理任务时不会被注销
+func (q *Queue[T]) forget(worker string) error {
+	return q.client.RunScript(forgetScript,
+		[]string{q.heartbeatKey(worker), q.processingKey(worker), q.leasesKey(worker), q.workersKey()}, worker).Err()
+}
+
+// StartReaper 每隔 interval 调用一次 Reap, 开启 Prom 时同时上报队列长度, 返回的 stop 停止后台任务
+func (q *Queue[T]) StartReaper(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if n, err := q.Reap(); err != nil {
+				zenlog.Error("queue %s reap fail: %+v", q.name, err)
+			} else if n > 0 {
+				zenlog.Warn("queue %s requeued %d stuck jobs", q.name, n)
+			}
+			if q.opts.Prom {
+				if stats, err := q.Stats(); err == nil {
+					prom.SetQueueDepthMetrics(q.name, stats.Pending, stats.Processing, stats.Dead, stats.OldestAge.Seconds())
+				}
+			}
+
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// Stats 统计队列, 处理中的任务数是所有 worker 的总和
+func (q *Queue[T]) Stats() (*Stats, error) {
+	stats := &Stats{}
+	var err error
+	if stats.Pending, err = q.client.LLen(q.pendingKey()).Result(); err != nil {
+		return nil, err
+	}
+	if stats.Dead, err = q.client.LLen(q.deadKey()).Result(); err != nil {
+		return nil, err
+	}
+	workers, err := q.client.SMembers(q.workersKey()).Result()
+	if err != nil {
+		return nil, err
+	}
+	for _, worker := range workers {
+		n, err := q.client.LLen(q.processingKey(worker)).Result()
+		if err != nil {
+			return nil, err
+		}
+		stats.Processing += n
+	}
+
+	// 任务从左边加入, 从右边取出, 最右边的任务等待得最久
+	oldest, err := q.client.LIndex(q.pendingKey(), -1).Result()
+	if err == redis.Nil {
+		return stats, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if parts := strings.SplitN(oldest, "|", 3); len(parts) == 3 {
+		if ms, err := strconv.ParseInt(parts[1], 10, 64); err == nil {
+			stats.OldestAge = time.Since(time.UnixMilli(ms))
+		}
+	}
+	return stats, nil
+}
+
+// DeadLetters 返回最早进入死信列表的 n 个任务, 无法解析的任务只有 ID
+func (q *Queue[T]) DeadLetters(n int64) ([]*Job[T], error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	raws, err := q.client.LRange(q.deadKey(), -n, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	jobs := make([]*Job[T], 0, len(raws))
+	for i := len(raws) - 1; i >= 0; i-- {
+		// 死信列表中的任务是 重试次数|任务
+		parts := strings.SplitN(raws[i], "|", 2)
+		attempts, _ := strconv.Atoi(parts[0])
+		job, _ := q.decode(parts[len(parts)-1])
+		job.Attempts = attempts
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// RequeueDead 把最早进入死信列表的 n 个任务放回队列并清零重试次数, 返回放回的任务数
+func (q *Queue[T]) RequeueDead(n int64) (int64, error) {
+	return q.client.RunScript(requeueDeadScript, []string{q.deadKey(), q.pendingKey()}, n).Int64()
+}
+
+// KEYS: 心跳, 处理中列表, 处理超时时间, worker 集合. ARGV: worker. 返回 1 表示已经注销
+var forgetScript = zredis.NewScript("QueueForget", `
+if redis.call("exists", KEYS[1]) == 1 or redis.call("llen", KEYS[2]) > 0 then
+	return 0
+end
+redis.call("del", KEYS[3])
+redis.call("srem", KEYS[4], ARGV[1])
+return 1`)
+
+// KEYS: 处理中列表, 处理超时时间. ARGV: 超时时间. 为处理中列表里没有处理超时时间的任务补上, 返回补上的任务数
+var leaseOrphansScript = zredis.NewScript("QueueLeaseOrphans", `
+local n = 0
+for _, raw in ipairs(redis.call("lrange", KEYS[1], 0, -1)) do
+	if not redis.call("zscore", KEYS[2], raw) then
+		redis.call("zadd", KEYS[2], ARGV[1], raw)
+		n = n + 1
+	end
+end
+return n`)
+
+// KEYS: 死信列表, 队列. ARGV: 数量. 去掉死信中的重试次数后放回队列
+var requeueDeadScript = zredis.NewScript("QueueRequeueDead", `
+local n = 0
+while n < tonumber(ARGV[1]) do
+	local raw = redis.call("rpop", KEYS[1])
+	if not raw then
+		break
+	end
+	redis.call("lpush", KEYS[2], string.match(raw, "^[^|]*|(.*)$") or raw)
+	n = n + 1
+end
+return n`)
@@ -0,0 +1,285 @@
+// Package ratelimit 基于 zredis 的集群限流, 每次判断在一个 Lua 脚本中原子完成, 多个进程共享同一份额度.
+// 时间使用调用方的本地时钟, 各个进程的时钟需要同步
+package ratelimit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/QuRuijie/zenDB/prom"
+	"github.com/QuRuijie/zenDB/zredis"
+	"github.com/pkg/errors"
+	"strconv"
+	"time"
+)
+
+// Algorithm 限流算法
+type Algorithm int
+
+const (
+	// FixedWindow 按固定时间窗口计数, 开销最小, 窗口交界处最多放行 2 倍请求
+	FixedWindow Algorithm = iota
+	// SlidingLog 记录窗口内每个请求的时间, 精确但内存随 Rate 增长
+	SlidingLog
+	// GCRA 通用信元速率算法(令牌桶), 请求均匀放行, 允许 Burst 个请求突发
+	GCRA
+)
+
+func (a Algorithm) String() string {
+	switch a {
+	case FixedWindow:
+		return "FixedWindow"
+	case SlidingLog:
+		return "SlidingLog"
+	case GCRA:
+		return "GCRA"
+	}
+	return "Algorithm(" + strconv.Itoa(int(a)) + ")"
+}
+
+var (
+	// ErrLimitExceeded 一次请求的数量超过上限, 永远不会放行
+	ErrLimitExceeded = errors.New("ratelimit: n exceeds limit")
+	// ErrWouldExceedDeadline Wait 需要等待的时间超过 ctx 的截止时间
+	ErrWouldExceedDeadline = errors.New("ratelimit: wait would exceed context deadline")
+)
+
+// Limit 每 Period 最多 Rate 个请求
+type Limit struct {
+	Rate   int
+	Period time.Duration
+	// Burst 只用于 GCRA, 可以同时放行的请求数, 默认等于 Rate
+	Burst int
+}
+
+func PerSecond(rate int) Limit {
+	return Limit{Rate: rate, Period: time.Second}
+}
+
+func PerMinute(rate int) Limit {
+	return Limit{Rate: rate, Period: time.Minute}
+}
+
+func PerHour(rate int) Limit {
+	return Limit{Rate: rate, Period: time.Hour}
+}
+
+// Result 一次判断的结果
+type Result struct {
+	Allowed bool
+	// Remaining 当前还可以放行的请求数
+	Remaining int
+	// RetryAfter 被限流时多久后可以重试, -1 表示 n 超过上限永远不会放行, 放行时为 0
+	RetryAfter time.Duration
+	// ResetAfter 多久后额度完全恢复
+	ResetAfter time.Duration
+}
+
+// Reservation Reserve 的结果, OK 时已经占用额度, 需要等待 Delay 后再执行
+type Reservation struct {
+	OK    bool
+	Delay time.Duration
+	Result
+}
+
+// Limiter 同一个 name 的限流器在所有进程间共享额度, key 区分限流对象(如 API key, 玩家 id)
+type Limiter struct {
+	client    *zredis.RedisClient
+	name      string
+	algorithm Algorithm
+	limit     Limit
+	prom      bool
+}
+
+// New 创建限流器, name 用于 redis key 前缀和 prom 指标的 limiter 标签
+func New(client *zredis.RedisClient, name string, algorithm Algorithm, limit Limit) *Limiter {
+	if limit.Burst <= 0 {
+		limit.Burst = limit.Rate
+	}
+	return &Limiter{client: client, name: name, algorithm: algorithm, limit: limit}
+}
+
+// NewWithProm 创建限流器, 并上报放行/限流次数
+func NewWithProm(client *zredis.RedisClient, name string, algorithm Algorithm, limit Limit) *Limiter {
+	l := New(client, name, algorithm, limit)
+	l.prom = true
+	return l
+}
+
+func (l *Limiter) Allow(key string) (*Result, error) {
+	return l.AllowN(key, 1)
+}
+
+// AllowN 判断是否放行 n 个请求, 放行时占用额度, 限流时不占用
+func (l *Limiter) AllowN(key string, n int) (*Result, error) {
+	res, err := l.run(key, n, false, 0)
+	if err != nil {
+		return nil, err
+	}
+	l.promMonitor(res.Allowed)
+	return &res.Result, nil
+}
+
+// Reserve 预约 n 个请求的额度, 额度不足时占用之后最早可用的额度, 调用方等待 Delay 后再执行.
+// maxDelay 小于 0 表示不限制等待时间, 需要等待超过 maxDelay 时不占用额度, 返回 OK 为 false.
+// FixedWindow 最多预约到下一个窗口
+func (l *Limiter) Reserve(key string, n int, maxDelay time.Duration) (*Reservation, error) {
+	res, err := l.run(key, n, true, maxDelay)
+	if err != nil {
+		return nil, err
+	}
+	l.promMonitor(res.OK)
+	return res, nil
+}
+
+func (l *Limiter) Wait(ctx context.Context, key string) error {
+	return l.WaitN(ctx, key, 1)
+}
+
+// WaitN 阻塞直到可以执行 n 个请求. 在 ctx 截止时间前等不到额度时立即返回 ErrWouldExceedDeadline,
+// 已经预约后 ctx 被取消时返回 ctx.Err(), 预约的额度不会退还.
+// FixedWindow 下一个窗口也已经满了时, 等到窗口切换后重新预约
+func (l *Limiter) WaitN(ctx context.Context, key string, n int) error {
+	for {
+		deadline, hasDeadline := ctx.Deadline()
+		maxDelay := time.Duration(-1)
+		if hasDeadline {
+			if maxDelay = time.Until(deadline); maxDelay < 0 {
+				return ctx.Err()
+			}
+		}
+
+		res, err := l.Reserve(key, n, maxDelay)
+		if err != nil {
+			return err
+		}
+		if res.OK {
+			return sleep(ctx, res.Delay)
+		}
+		if res.RetryAfter < 0 {
+			return errors.Wrapf(ErrLimitExceeded, "n %d", n)
+		}
+		if hasDeadline && res.RetryAfter > maxDelay {
+			return ErrWouldExceedDeadline
+		}
+		if err = sleep(ctx, res.RetryAfter); err != nil {
+			return err
+		}
+	}
+}
+
+// sleep 等待 d, ctx 结束时返回 ctx.Err()
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Reset 清除 key 的用量
+func (l *Limiter) Reset(key string) error {
+	switch l.algorithm {
+	case FixedWindow:
+		window := l.limit.Period.Microseconds()
+		keys := l.windowKeys(key, time.Now().UnixMicro()/window)
+		return l.client.Del(keys...).Err()
+	default:
+		return l.client.Del(l.key(key)).Err()
+	}
+}
+
+func (l *Limiter) run(key string, n int, reserve bool, maxDelay time.Duration) (*Reservation, error) {
+	if l.limit.Rate <= 0 || l.limit.Period <= 0 {
+		return nil, errors.Errorf("ratelimit: invalid limit %+v", l.limit)
+	}
+	reserveArg := "0"
+	if reserve {
+		reserveArg = "1"
+	}
+	maxDelayArg := int64(-1)
+	if maxDelay >= 0 {
+		maxDelayArg = maxDelay.Microseconds()
+	}
+	now := time.Now().UnixMicro()
+	window := l.limit.Period.Microseconds()
+
+	var cmd *zredis.ScriptCmd
+	switch l.algorithm {
+	case FixedWindow:
+		index := now / window
+		reset := (index+1)*window - now
+		cmd = l.client.RunScript(fixedWindowScript, l.windowKeys(key, index),
+			l.limit.Rate, window, reset, n, reserveArg, maxDelayArg)
+	case SlidingLog:
+		member, err := newMember()
+		if err != nil {
+			return nil, err
+		}
+		cmd = l.client.RunScript(slidingLogScript, []string{l.key(key)},
+			l.limit.Rate, window, now, n, reserveArg, maxDelayArg, member)
+	case GCRA:
+		emission := float64(window) / float64(l.limit.Rate)
+		cmd = l.client.RunScript(gcraScript, []string{l.key(key)},
+			strconv.FormatFloat(emission, 'f', -1, 64), l.limit.Burst, now, n, reserveArg, maxDelayArg)
+	default:
+		return nil, errors.Errorf("ratelimit: unknown algorithm %v", l.algorithm)
+	}
+
+	vals, err := cmd.Int64Slice()
+	if err != nil {
+		return nil, errors.Wrapf(err, "ratelimit %s", l.name)
+	}
+	if len(vals) != 4 {
+		return nil, errors.Errorf("ratelimit %s: unexpected script result %v", l.name, vals)
+	}
+	res := &Reservation{OK: vals[0] == 1}
+	res.Allowed = res.OK
+	res.Remaining = int(vals[1])
+	res.ResetAfter = time.Duration(vals[3]) * time.Microsecond
+	switch {
+	case vals[2] < 0:
+		res.RetryAfter = -1
+	case res.OK:
+		res.Delay = time.Duration(vals[2]) * time.Microsecond
+		res.Allowed = res.Delay == 0
+	default:
+		res.RetryAfter = time.Duration(vals[2]) * time.Microsecond
+	}
+	return res, nil
+}
+
+// key 用 {} 包住限流对象, Cluster 模式下同一个对象的 key 在同一个 slot
+func (l *Limiter) key(key string) string {
+	return "ratelimit:" + l.name + ":{" + key + "}"
+}
+
+func (l *Limiter) windowKeys(key string, index int64) []string {
+	base := l.key(key) + ":"
+	return []string{base + strconv.FormatInt(index, 10), base + strconv.FormatInt(index+1, 10)}
+}
+
+func (l *Limiter) promMonitor(allowed bool) {
+	if !l.prom {
+		return
+	}
+	result := "Allowed"
+	if !allowed {
+		result = "Limited"
+	}
+	prom.SetRateLimitMetrics(l.name, result)
+}
+
+func newMember() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "ratelimit: generate member")
+	}
+	return hex.EncodeToString(b) + ":", nil
+}
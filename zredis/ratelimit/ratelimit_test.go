@@ -0,0 +1,124 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/QuRuijie/zenDB/zredis"
+	"github.com/go-redis/redis"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+	"testing"
+	"time"
+)
+
+const (
+	ADDR = "localhost"
+	PORT = "6379"
+)
+
+func TestRateLimit(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "RateLimit")
+}
+
+var _ = Describe("Test Limiter", func() {
+
+	var client *zredis.RedisClient
+
+	BeforeEach(func() {
+		client = zredis.NewClient(&redis.Options{Addr: ADDR + ":" + PORT, DB: 15}, "test")
+		Expect(client.FlushDB().Err()).ShouldNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(client.Close()).ShouldNot(HaveOccurred())
+	})
+
+	for _, algorithm := range []Algorithm{FixedWindow, SlidingLog, GCRA} {
+		algorithm := algorithm
+
+		It("Test AllowN "+algorithm.String(), func() {
+			l := New(client, "test", algorithm, PerMinute(3))
+			// 超过上限的请求在空 key 上也永远不会被允许
+			res, err := l.AllowN("fresh", 4)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(res.Allowed).Should(BeFalse())
+			Expect(res.RetryAfter).Should(Equal(time.Duration(-1)))
+
+			for i := 2; i >= 0; i-- {
+				res, err := l.Allow("user")
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(res.Allowed).Should(BeTrue())
+				Expect(res.Remaining).Should(Equal(i))
+			}
+
+			res, err = l.Allow("user")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(res.Allowed).Should(BeFalse())
+			Expect(res.RetryAfter).Should(BeNumerically(">", 0))
+
+			res, err = l.AllowN("user", 4)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(res.RetryAfter).Should(Equal(time.Duration(-1)))
+
+			res, err = l.Allow("other")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(res.Allowed).Should(BeTrue())
+
+			Expect(l.Reset("user")).ShouldNot(HaveOccurred())
+			res, err = l.Allow("user")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(res.Allowed).Should(BeTrue())
+		})
+
+		It("Test Wait "+algorithm.String(), func() {
+			l := New(client, "test", algorithm, Limit{Rate: 2, Period: 200 * time.Millisecond})
+			// 从窗口开始时计时, FixedWindow 的第 3 个请求需要等到下一个窗口
+			window := (200 * time.Millisecond).Microseconds()
+			time.Sleep(time.Duration(window-time.Now().UnixMicro()%window) * time.Microsecond)
+			start := time.Now()
+			for i := 0; i < 3; i++ {
+				Expect(l.Wait(context.Background(), "user")).ShouldNot(HaveOccurred())
+			}
+			Expect(time.Since(start)).Should(BeNumerically(">=", 50*time.Millisecond))
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+			defer cancel()
+			err := l.WaitN(ctx, "user", 2)
+			Expect(errors.Is(err, ErrWouldExceedDeadline)).Should(BeTrue())
+			err = l.WaitN(context.Background(), "user", 3)
+			Expect(errors.Is(err, ErrLimitExceeded)).Should(BeTrue())
+		})
+	}
+
+	It("Test Wait FixedWindow after next window is full", func() {
+		l := New(client, "test", FixedWindow, Limit{Rate: 2, Period: 100 * time.Millisecond})
+		res, err := l.AllowN("user", 2)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(res.Allowed).Should(BeTrue())
+		reservation, err := l.Reserve("user", 2, -1)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(reservation.OK).Should(BeTrue())
+
+		Expect(l.WaitN(context.Background(), "user", 2)).ShouldNot(HaveOccurred())
+		res, err = l.Allow("user")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(res.Allowed).Should(BeFalse())
+	})
+
+	It("Test Reserve GCRA", func() {
+		l := New(client, "test", GCRA, Limit{Rate: 10, Period: time.Second, Burst: 1})
+		res, err := l.Reserve("user", 1, -1)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(res.OK && res.Allowed).Should(BeTrue())
+
+		res, err = l.Reserve("user", 1, -1)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(res.OK).Should(BeTrue())
+		Expect(res.Delay).Should(BeNumerically("~", 100*time.Millisecond, 10*time.Millisecond))
+
+		res, err = l.Reserve("user", 1, 50*time.Millisecond)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(res.OK).Should(BeFalse())
+	})
+})
@@ -0,0 +1,117 @@
+package ratelimit
+
+import "github.com/QuRuijie/zenDB/zredis"
+
+// 脚本的时间单位都是微秒, 返回 {allowed, remaining, retry_after 或 reserve 的 delay, reset_after}.
+// retry_after 为 -1 表示 n 超过上限, 永远不会放行.
+// redis.call 会把 Lua 数字按 %.14g 转成字符串, 微秒时间戳有 16 位, 写入前用 %.0f 格式化
+
+// KEYS: 当前窗口, 下一个窗口. ARGV: limit, window, reset, n, reserve, max_delay
+var fixedWindowScript = zredis.NewScript("RateLimitFixedWindow", `
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local reset = tonumber(ARGV[3])
+local n = tonumber(ARGV[4])
+local reserve = ARGV[5] == "1"
+local max_delay = tonumber(ARGV[6])
+
+local used = tonumber(redis.call("get", KEYS[1]) or "0")
+if used + n <= limit then
+	used = redis.call("incrby", KEYS[1], n)
+	redis.call("pexpire", KEYS[1], math.ceil(reset / 1000))
+	return {1, limit - used, 0, reset}
+end
+if n > limit then
+	return {0, limit - used, -1, reset}
+end
+if reserve and (max_delay < 0 or reset <= max_delay) then
+	local next_used = tonumber(redis.call("get", KEYS[2]) or "0")
+	if next_used + n <= limit then
+		next_used = redis.call("incrby", KEYS[2], n)
+		redis.call("pexpire", KEYS[2], math.ceil((reset + window) / 1000))
+		return {1, limit - next_used, reset, reset + window}
+	end
+end
+return {0, math.max(limit - used, 0), reset, reset}`)
+
+// KEYS: 请求时间的有序集合. ARGV: limit, window, now, n, reserve, max_delay, member 前缀.
+// 预约的请求以未来的时间加入集合, 在这之前也计入用量
+var slidingLogScript = zredis.NewScript("RateLimitSlidingLog", `
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local n = tonumber(ARGV[4])
+local reserve = ARGV[5] == "1"
+local max_delay = tonumber(ARGV[6])
+
+local function add(at)
+	local score = string.format("%.0f", at)
+	for i = 1, n do
+		redis.call("zadd", KEYS[1], score, ARGV[7] .. i)
+	end
+	local ttl = math.ceil((at - now + window) / 1000)
+	if redis.call("pttl", KEYS[1]) < ttl then
+		redis.call("pexpire", KEYS[1], ttl)
+	end
+end
+
+redis.call("zremrangebyscore", KEYS[1], "-inf", string.format("%.0f", now - window))
+local count = redis.call("zcard", KEYS[1])
+if count + n <= limit then
+	add(now)
+	return {1, limit - count - n, 0, window}
+end
+local remaining = math.max(limit - count, 0)
+if n > limit then
+	local reset = 0
+	if count > 0 then
+		local first = redis.call("zrange", KEYS[1], 0, 0, "withscores")
+		reset = tonumber(first[2]) + window - now
+	end
+	return {0, remaining, -1, reset}
+end
+local first = redis.call("zrange", KEYS[1], 0, 0, "withscores")
+local reset = tonumber(first[2]) + window - now
+local oldest = redis.call("zrange", KEYS[1], count + n - limit - 1, count + n - limit - 1, "withscores")
+local delay = tonumber(oldest[2]) + window - now
+if reserve and (max_delay < 0 or delay <= max_delay) then
+	add(now + delay)
+	return {1, 0, delay, delay + window}
+end
+return {0, remaining, delay, reset}`)
+
+// KEYS: 理论到达时间(TAT). ARGV: emission, burst, now, n, reserve, max_delay.
+// emission 为两个请求之间的间隔, burst 个请求可以同时放行
+var gcraScript = zredis.NewScript("RateLimitGCRA", `
+local emission = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local n = tonumber(ARGV[4])
+local reserve = ARGV[5] == "1"
+local max_delay = tonumber(ARGV[6])
+local tolerance = emission * burst
+
+local tat = tonumber(redis.call("get", KEYS[1]) or "0")
+if tat < now then
+	tat = now
+end
+local new_tat = tat + emission * n
+local delay = new_tat - tolerance - now
+
+local function save()
+	redis.call("set", KEYS[1], string.format("%.0f", new_tat), "px", math.ceil((new_tat - now) / 1000))
+end
+
+if delay <= 0 then
+	save()
+	return {1, math.floor(-delay / emission + 1e-9), 0, math.ceil(new_tat - now)}
+end
+local remaining = math.max(math.floor((now - tat + tolerance) / emission + 1e-9), 0)
+if n > burst then
+	return {0, remaining, -1, math.ceil(tat - now)}
+end
+if reserve and (max_delay < 0 or delay <= max_delay) then
+	save()
+	return {1, 0, math.ceil(delay), math.ceil(new_tat - now)}
+end
+return {0, remaining, math.ceil(delay), math.ceil(tat - now)}`)
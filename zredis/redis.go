@@ -1,8 +1,11 @@
 package zredis
 
 import (
+	"github.com/QuRuijie/zenDB/breaker"
+	"github.com/QuRuijie/zenDB/lifecycle"
 	"github.com/QuRuijie/zenDB/prom"
 	"github.com/Zentertain/zenlog"
+	"sync"
 	"time"
 
 	"github.com/go-redis/redis"
@@ -13,10 +16,26 @@ const (
 	SPIN_NUM  = 10
 )
 
+// Client 单机, Sentinel(redis.NewFailoverClient), Cluster 和 Ring 客户端共同的接口
+type Client interface {
+	redis.UniversalClient
+	PoolStats() *redis.PoolStats
+}
+
+var (
+	_ Client = (*redis.Client)(nil)
+	_ Client = (*redis.ClusterClient)(nil)
+	_ Client = (*redis.Ring)(nil)
+)
+
+// RedisClient 封装的命令在所有模式下都经过熔断, 关闭和 prom 监控
 type RedisClient struct {
-	*redis.Client
+	Client
 	clientName string
 	prom       bool
+	breaker    *breaker.Breaker
+	life       *lifecycle.Tracker
+	scripts    *scriptRegistry
 }
 
 type RedisPipeliner struct {
@@ -25,30 +44,46 @@ type RedisPipeliner struct {
 
 //------------------------------------Public----------------------------------------
 
+// NewRedisClient 连不上 redis 时记录错误并返回客户端, go-redis 会在 redis 恢复后自动重连, 需要返回错误时使用 Connect
 func NewRedisClient(opt *redis.Options) *redis.Client {
 	r := redis.NewClient(opt)
 	_, err := r.Ping().Result()
 	if err != nil {
-		zenlog.Fatal("zredis cannot connect:%+v", err)
+		zenlog.Error("zredis cannot connect:%+v", err)
 	}
 	return r
 }
 
+// NewClient 启动时按 DefaultConnectOptions 重试, 仍然连不上时不退出进程, 返回的客户端在 redis 恢复后可用
 func NewClient(opt *redis.Options, clientName string) *RedisClient {
-	return &RedisClient{NewRedisClient(opt), clientName,false}
+	return connectOrDegrade(opt, clientName, false)
 }
 
 func NewClientWithProm(opt *redis.Options, clientName string) *RedisClient {
-	return &RedisClient{NewRedisClient(opt), clientName,true}
+	return connectOrDegrade(opt, clientName, true)
 }
 
+// Keys Cluster 和 Ring 模式下合并所有节点的结果
 func (c RedisClient) Keys(pattern string) (cmd *redis.StringSliceCmd) {
-	defer func(startTime int64) { c.promMonitor(startTime,"Keys",cmd.Err()) }(prom.NowMillisecond())
+	if err := c.allow("Keys"); err != nil {
+		return redis.NewStringSliceResult(nil, err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"Keys",cmd.Err()) }(prom.NowMicrosecond())
+	switch client := c.Client.(type) {
+	case *redis.ClusterClient:
+		return keysOfNodes(client.ForEachMaster, pattern)
+	case *redis.Ring:
+		return keysOfNodes(client.ForEachShard, pattern)
+	}
 	return c.Client.Keys(pattern)
 }
 
+// Scan Cluster 和 Ring 模式下只扫描 key 所在的一个节点, 需要全量扫描时用 ForEachMaster/ForEachShard 逐个节点扫描
 func (c RedisClient) Scan(cursor uint64, match string, count int64) (cmd *redis.ScanCmd) {
-	defer func(startTime int64) { c.promMonitor(startTime,"Scan",cmd.Err()) }(prom.NowMillisecond())
+	if err := c.allow("Scan"); err != nil {
+		return redis.NewScanCmdResult(nil, 0, err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"Scan",cmd.Err()) }(prom.NowMicrosecond())
 	return c.Client.Scan(cursor, match, count)
 }
 
@@ -77,10 +112,12 @@ func (pipe RedisPipeliner) Exec() (cmd []redis.Cmder, err error) {
 	return pipe.Pipeliner.Exec()
 }
 
+// Deprecated: 锁过期后慢的持有者会删除别人的锁, 使用 TryLock/AcquireLock
 func SetJobMux(r *RedisClient, key, val string, expireTime time.Duration) bool {
 	return SetJobMuxWithSpins(r, key, val, expireTime, SPIN_TIME, SPIN_NUM)
 }
 
+// Deprecated: 使用 AcquireLock
 func SetJobMuxWithSpins(r *RedisClient, key, val string, expireTime, spinTime time.Duration, spinNum int) bool {
 	for i := 0; i < spinNum; i++ {
 		isSet := r.SetNX(key, val, expireTime)
@@ -93,6 +130,7 @@ func SetJobMuxWithSpins(r *RedisClient, key, val string, expireTime, spinTime ti
 	return false
 }
 
+// Deprecated: 不检查持有者, 使用 Lock.Unlock
 func ReleaseJobMux(r *RedisClient, key string) {
 	r.Unlink(key)
 }
@@ -100,190 +138,300 @@ func ReleaseJobMux(r *RedisClient, key string) {
 //------------------------------------Key----------------------------------------
 
 func (c RedisClient) Get(key string) (cmd *redis.StringCmd) {
-	defer func(startTime int64) { c.promMonitor(startTime,"Get",cmd.Err()) }(prom.NowMillisecond())
+	if err := c.allow("Get"); err != nil {
+		return redis.NewStringResult("", err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"Get",cmd.Err()) }(prom.NowMicrosecond())
 	return c.Client.Get(key)
 }
 
 func (c RedisClient) Set(key string, value interface{}, expiration time.Duration) (cmd *redis.StatusCmd) {
-	defer func(startTime int64) { c.promMonitor(startTime,"Set",cmd.Err()) }(prom.NowMillisecond())
+	if err := c.allow("Set"); err != nil {
+		return redis.NewStatusResult("", err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"Set",cmd.Err()) }(prom.NowMicrosecond())
 	return c.Client.Set(key, value, expiration)
 }
 
 func (c RedisClient) Del(keys ...string) (cmd *redis.IntCmd) {
-	defer func(startTime int64) { c.promMonitor(startTime,"Del",cmd.Err()) }(prom.NowMillisecond())
+	if err := c.allow("Del"); err != nil {
+		return redis.NewIntResult(0, err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"Del",cmd.Err()) }(prom.NowMicrosecond())
 	return c.Client.Del(keys...)
 }
 
 // ?
 func (c RedisClient) Unlink(key ...string) (cmd *redis.IntCmd) {
 	//redis support unlink from 4.0
-	defer func(startTime int64) { c.promMonitor(startTime,"Unlink",cmd.Err()) }(prom.NowMillisecond())
+	if err := c.allow("Unlink"); err != nil {
+		return redis.NewIntResult(0, err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"Unlink",cmd.Err()) }(prom.NowMicrosecond())
 	cmd = c.Client.Unlink(key...)
 	if cmd.Err() != nil {
-		return c.Del(key...)
+		return c.Client.Del(key...)
 	}
 	return
 }
 
+func (c RedisClient) Exists(keys ...string) (cmd *redis.IntCmd) {
+	if err := c.allow("Exists"); err != nil {
+		return redis.NewIntResult(0, err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"Exists",cmd.Err()) }(prom.NowMicrosecond())
+	return c.Client.Exists(keys...)
+}
+
 func (c RedisClient) Incr(key string) (cmd *redis.IntCmd) {
-	defer func(startTime int64) { c.promMonitor(startTime,"Incr",cmd.Err()) }(prom.NowMillisecond())
+	if err := c.allow("Incr"); err != nil {
+		return redis.NewIntResult(0, err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"Incr",cmd.Err()) }(prom.NowMicrosecond())
 	return c.Client.Incr(key)
 }
 
 func (c RedisClient) SetNX(key string, value interface{}, expiration time.Duration) (cmd *redis.BoolCmd) {
-	defer func(startTime int64) { c.promMonitor(startTime,"SetNX",cmd.Err()) }(prom.NowMillisecond())
+	if err := c.allow("SetNX"); err != nil {
+		return redis.NewBoolResult(false, err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"SetNX",cmd.Err()) }(prom.NowMicrosecond())
 	return c.Client.SetNX(key, value, expiration)
 }
 
 func (c RedisClient) Expire(key string, expiration time.Duration) (cmd *redis.BoolCmd) {
-	defer func(startTime int64) { c.promMonitor(startTime,"Expire",cmd.Err()) }(prom.NowMillisecond())
+	if err := c.allow("Expire"); err != nil {
+		return redis.NewBoolResult(false, err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"Expire",cmd.Err()) }(prom.NowMicrosecond())
 	return c.Client.Expire(key, expiration)
 }
 
 func (c RedisClient) ExpireAt(key string, tm time.Time) (cmd *redis.BoolCmd) {
-	defer func(startTime int64) { c.promMonitor(startTime,"ExpireAt",cmd.Err()) }(prom.NowMillisecond())
+	if err := c.allow("ExpireAt"); err != nil {
+		return redis.NewBoolResult(false, err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"ExpireAt",cmd.Err()) }(prom.NowMicrosecond())
 	return c.Client.ExpireAt(key, tm)
 }
 
 func (c RedisClient) Rename(key, newkey string) (cmd *redis.StatusCmd) {
-	defer func(startTime int64) { c.promMonitor(startTime,"Rename",cmd.Err()) }(prom.NowMillisecond())
+	if err := c.allow("Rename"); err != nil {
+		return redis.NewStatusResult("", err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"Rename",cmd.Err()) }(prom.NowMicrosecond())
 	return c.Client.Rename(key, newkey)
 }
 
 //------------------------------------Hash------------------------------------------
 
 func (c RedisClient) HSet(key, field string, value interface{}) (cmd *redis.BoolCmd) {
-	defer func(startTime int64) { c.promMonitor(startTime,"HSet",cmd.Err()) }(prom.NowMillisecond())
+	if err := c.allow("HSet"); err != nil {
+		return redis.NewBoolResult(false, err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"HSet",cmd.Err()) }(prom.NowMicrosecond())
 	return c.Client.HSet(key, field, value)
 }
 
 func (c RedisClient) HGet(key, field string) (cmd *redis.StringCmd) {
-	defer func(startTime int64) { c.promMonitor(startTime,"HGet",cmd.Err()) }(prom.NowMillisecond())
+	if err := c.allow("HGet"); err != nil {
+		return redis.NewStringResult("", err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"HGet",cmd.Err()) }(prom.NowMicrosecond())
 	return c.Client.HGet(key, field)
 }
 
 func (c RedisClient) HDel(key string, fields ...string) (cmd *redis.IntCmd) {
-	defer func(startTime int64) { c.promMonitor(startTime,"HDel",cmd.Err()) }(prom.NowMillisecond())
+	if err := c.allow("HDel"); err != nil {
+		return redis.NewIntResult(0, err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"HDel",cmd.Err()) }(prom.NowMicrosecond())
 	return c.Client.HDel(key, fields...)
 }
 
 func (c RedisClient) HExists(key, field string) (cmd *redis.BoolCmd) {
-	defer func(startTime int64) { c.promMonitor(startTime,"HExists",cmd.Err()) }(prom.NowMillisecond())
+	if err := c.allow("HExists"); err != nil {
+		return redis.NewBoolResult(false, err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"HExists",cmd.Err()) }(prom.NowMicrosecond())
 	return c.Client.HExists(key, field)
 }
 
 func (c RedisClient) HGetAll(key string) (cmd *redis.StringStringMapCmd) {
-	defer func(startTime int64) { c.promMonitor(startTime,"HGetAll",cmd.Err()) }(prom.NowMillisecond())
+	if err := c.allow("HGetAll"); err != nil {
+		return redis.NewStringStringMapResult(nil, err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"HGetAll",cmd.Err()) }(prom.NowMicrosecond())
 	return c.Client.HGetAll(key)
 }
 
 func (c RedisClient) HIncrBy(key, field string, incr int64) (cmd *redis.IntCmd) {
-	defer func(startTime int64) { c.promMonitor(startTime,"HIncrBy",cmd.Err()) }(prom.NowMillisecond())
+	if err := c.allow("HIncrBy"); err != nil {
+		return redis.NewIntResult(0, err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"HIncrBy",cmd.Err()) }(prom.NowMicrosecond())
 	return c.Client.HIncrBy(key, field, incr)
 }
 
 func (c RedisClient) HMSet(key string, fields map[string]interface{}) (cmd *redis.StatusCmd) {
-	defer func(startTime int64) { c.promMonitor(startTime,"HMSet",cmd.Err()) }(prom.NowMillisecond())
+	if err := c.allow("HMSet"); err != nil {
+		return redis.NewStatusResult("", err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"HMSet",cmd.Err()) }(prom.NowMicrosecond())
 	return c.Client.HMSet(key, fields)
 }
 
 func (c RedisClient) HMGet(key string, fields ...string) (cmd *redis.SliceCmd) {
-	defer func(startTime int64) { c.promMonitor(startTime,"HMGet",cmd.Err()) }(prom.NowMillisecond())
+	if err := c.allow("HMGet"); err != nil {
+		return redis.NewSliceResult(nil, err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"HMGet",cmd.Err()) }(prom.NowMicrosecond())
 	return c.Client.HMGet(key, fields...)
 }
 
 func (c RedisClient) HSetNX(key, field string, value interface{}) (cmd *redis.BoolCmd) {
-	defer func(startTime int64) { c.promMonitor(startTime,"HSetNX",cmd.Err()) }(prom.NowMillisecond())
+	if err := c.allow("HSetNX"); err != nil {
+		return redis.NewBoolResult(false, err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"HSetNX",cmd.Err()) }(prom.NowMicrosecond())
 	return c.Client.HSetNX(key, field, value)
 }
 
 func (c RedisClient) HScan(key string, cursor uint64, match string, count int64) (cmd *redis.ScanCmd) {
-	defer func(startTime int64) { c.promMonitor(startTime,"HScan",cmd.Err()) }(prom.NowMillisecond())
+	if err := c.allow("HScan"); err != nil {
+		return redis.NewScanCmdResult(nil, 0, err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"HScan",cmd.Err()) }(prom.NowMicrosecond())
 	return c.Client.HScan(key, cursor, match, count)
 }
 
 //------------------------------------Set-------------------------------------------
 
 func (c RedisClient) SAdd(key string, members ...interface{}) (cmd *redis.IntCmd) {
-	defer func(startTime int64) { c.promMonitor(startTime,"SAdd",cmd.Err()) }(prom.NowMillisecond())
+	if err := c.allow("SAdd"); err != nil {
+		return redis.NewIntResult(0, err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"SAdd",cmd.Err()) }(prom.NowMicrosecond())
 	return c.Client.SAdd(key, members...)
 }
 
 func (c RedisClient) SCard(key string) (cmd *redis.IntCmd) {
-	defer func(startTime int64) { c.promMonitor(startTime,"SCard",cmd.Err()) }(prom.NowMillisecond())
+	if err := c.allow("SCard"); err != nil {
+		return redis.NewIntResult(0, err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"SCard",cmd.Err()) }(prom.NowMicrosecond())
 	return c.Client.SCard(key)
 }
 
 func (c RedisClient) SIsMember(key string, member interface{}) (cmd *redis.BoolCmd) {
-	defer func(startTime int64) { c.promMonitor(startTime,"SIsMember",cmd.Err()) }(prom.NowMillisecond())
+	if err := c.allow("SIsMember"); err != nil {
+		return redis.NewBoolResult(false, err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"SIsMember",cmd.Err()) }(prom.NowMicrosecond())
 	return c.Client.SIsMember(key, member)
 }
 
 func (c RedisClient) SMembers(key string) (cmd *redis.StringSliceCmd) {
-	defer func(startTime int64) { c.promMonitor(startTime,"SMembers",cmd.Err()) }(prom.NowMillisecond())
+	if err := c.allow("SMembers"); err != nil {
+		return redis.NewStringSliceResult(nil, err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"SMembers",cmd.Err()) }(prom.NowMicrosecond())
 	return c.Client.SMembers(key)
 }
 
 func (c RedisClient) SRem(key string, members ...interface{}) (cmd *redis.IntCmd) {
-	defer func(startTime int64) { c.promMonitor(startTime,"SRem",cmd.Err()) }(prom.NowMillisecond())
+	if err := c.allow("SRem"); err != nil {
+		return redis.NewIntResult(0, err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"SRem",cmd.Err()) }(prom.NowMicrosecond())
 	return c.Client.SRem(key, members...)
 }
 
 //------------------------------------ZSet------------------------------------------
 
 func (c RedisClient) ZAdd(key string, members ...redis.Z) (cmd *redis.IntCmd) {
-	defer func(startTime int64) { c.promMonitor(startTime,"ZAdd",cmd.Err()) }(prom.NowMillisecond())
+	if err := c.allow("ZAdd"); err != nil {
+		return redis.NewIntResult(0, err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"ZAdd",cmd.Err()) }(prom.NowMicrosecond())
 	return c.Client.ZAdd(key, members...)
 }
 
 func (c RedisClient) ZScore(key, member string) (cmd *redis.FloatCmd) {
-	defer func(startTime int64) { c.promMonitor(startTime,"ZScore",cmd.Err()) }(prom.NowMillisecond())
+	if err := c.allow("ZScore"); err != nil {
+		return redis.NewFloatResult(0, err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"ZScore",cmd.Err()) }(prom.NowMicrosecond())
 	return c.Client.ZScore(key, member)
 }
 
 func (c RedisClient) ZAddXX(key string, members ...redis.Z) (cmd *redis.IntCmd) {
-	defer func(startTime int64) { c.promMonitor(startTime,"ZAddXX",cmd.Err()) }(prom.NowMillisecond())
+	if err := c.allow("ZAddXX"); err != nil {
+		return redis.NewIntResult(0, err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"ZAddXX",cmd.Err()) }(prom.NowMicrosecond())
 	return c.Client.ZAddXX(key, members...)
 }
 
 func (c RedisClient) ZAddNX(key string, members ...redis.Z) (cmd *redis.IntCmd) {
-	defer func(startTime int64) { c.promMonitor(startTime,"ZAddNX",cmd.Err()) }(prom.NowMillisecond())
+	if err := c.allow("ZAddNX"); err != nil {
+		return redis.NewIntResult(0, err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"ZAddNX",cmd.Err()) }(prom.NowMicrosecond())
 	return c.Client.ZAddNX(key, members...)
 }
 
 func (c RedisClient) ZCard(key string) (cmd *redis.IntCmd) {
-	defer func(startTime int64) { c.promMonitor(startTime,"ZCard",cmd.Err()) }(prom.NowMillisecond())
+	if err := c.allow("ZCard"); err != nil {
+		return redis.NewIntResult(0, err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"ZCard",cmd.Err()) }(prom.NowMicrosecond())
 	return c.Client.ZCard(key)
 }
 
 func (c RedisClient) ZRem(key string, members ...interface{}) (cmd *redis.IntCmd) {
-	defer func(startTime int64) { c.promMonitor(startTime,"ZRem",cmd.Err()) }(prom.NowMillisecond())
+	if err := c.allow("ZRem"); err != nil {
+		return redis.NewIntResult(0, err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"ZRem",cmd.Err()) }(prom.NowMicrosecond())
 	return c.Client.ZRem(key, members...)
 }
 
 func (c RedisClient) ZCount(key, min, max string) (cmd *redis.IntCmd) {
-	defer func(startTime int64) { c.promMonitor(startTime,"ZCount",cmd.Err()) }(prom.NowMillisecond())
+	if err := c.allow("ZCount"); err != nil {
+		return redis.NewIntResult(0, err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"ZCount",cmd.Err()) }(prom.NowMicrosecond())
 	return c.Client.ZCount(key, min, max)
 }
 
 // ?
 func (c RedisClient) ZIncr(key string, member redis.Z) (cmd *redis.FloatCmd) {
-	defer func(startTime int64) { c.promMonitor(startTime,"ZIncr",cmd.Err()) }(prom.NowMillisecond())
+	if err := c.allow("ZIncr"); err != nil {
+		return redis.NewFloatResult(0, err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"ZIncr",cmd.Err()) }(prom.NowMicrosecond())
 	cmd = c.Client.ZIncr(key, member)
 	if cmd.Err() != nil {
-		return c.ZIncrBy(key, member.Score, member.Member.(string))
+		return c.Client.ZIncrBy(key, member.Score, member.Member.(string))
 	}
 	return cmd
 }
 
 func (c RedisClient) ZIncrBy(key string, increment float64, member string) (cmd *redis.FloatCmd) {
-	defer func(startTime int64) { c.promMonitor(startTime,"ZIncrBy",cmd.Err()) }(prom.NowMillisecond())
+	if err := c.allow("ZIncrBy"); err != nil {
+		return redis.NewFloatResult(0, err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"ZIncrBy",cmd.Err()) }(prom.NowMicrosecond())
 	return c.Client.ZIncrBy(key, increment, member)
 }
 
 //ZRankX 返回正序 or 倒序
 func (c RedisClient) ZRankX(key, member string, rev bool) (cmd *redis.IntCmd) {
-	defer func(startTime int64) { c.promMonitor(startTime,"ZRankX",cmd.Err()) }(prom.NowMillisecond())
+	if err := c.allow("ZRankX"); err != nil {
+		return redis.NewIntResult(0, err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"ZRankX",cmd.Err()) }(prom.NowMicrosecond())
 	if rev {
 		return c.Client.ZRevRank(key, member) //返回member排名
 	}
@@ -292,7 +440,10 @@ func (c RedisClient) ZRankX(key, member string, rev bool) (cmd *redis.IntCmd) {
 
 //ZRangeWithScoresX 返回正序 or 倒序的名次范围的zSet
 func (c RedisClient) ZRangeWithScoresX(key string, start, stop int64, rev bool) (cmd *redis.ZSliceCmd) {
-	defer func(startTime int64) { c.promMonitor(startTime,"ZRangeWithScoresX",cmd.Err()) }(prom.NowMillisecond())
+	if err := c.allow("ZRangeWithScoresX"); err != nil {
+		return redis.NewZSliceCmdResult(nil, err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"ZRangeWithScoresX",cmd.Err()) }(prom.NowMicrosecond())
 	if rev {
 		return c.Client.ZRevRangeWithScores(key, start, stop)
 	}
@@ -301,59 +452,140 @@ func (c RedisClient) ZRangeWithScoresX(key string, start, stop int64, rev bool)
 
 //ZRangeX 返回正序 or 倒序的名次范围的zSet.Member
 func (c RedisClient) ZRangeX(key string, start, stop int64, rev bool) (cmd *redis.StringSliceCmd) {
-	defer func(startTime int64) { c.promMonitor(startTime,"ZRangeX",cmd.Err()) }(prom.NowMillisecond())
+	if err := c.allow("ZRangeX"); err != nil {
+		return redis.NewStringSliceResult(nil, err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"ZRangeX",cmd.Err()) }(prom.NowMicrosecond())
 	if rev {
 		return c.Client.ZRevRange(key, start, stop)
 	}
 	return c.Client.ZRange(key, start, stop)
 }
 
+func (c RedisClient) ZRangeByScore(key string, opt redis.ZRangeBy) (cmd *redis.StringSliceCmd) {
+	if err := c.allow("ZRangeByScore"); err != nil {
+		return redis.NewStringSliceResult(nil, err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"ZRangeByScore",cmd.Err()) }(prom.NowMicrosecond())
+	return c.Client.ZRangeByScore(key, opt)
+}
+
 //ZRemRangeByRank 根据倒序排名移出
 func (c RedisClient) ZRemRangeByRank(key string, start, stop int64) (cmd *redis.IntCmd) {
-	defer func(startTime int64) { c.promMonitor(startTime,"ZRemRangeByRank",cmd.Err()) }(prom.NowMillisecond())
+	if err := c.allow("ZRemRangeByRank"); err != nil {
+		return redis.NewIntResult(0, err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"ZRemRangeByRank",cmd.Err()) }(prom.NowMicrosecond())
 	return c.Client.ZRemRangeByRank(key, start, stop)
 }
 
 //------------------------------------List------------------------------------------
 
 func (c RedisClient) LPush(key string, values ...interface{}) (cmd *redis.IntCmd) {
-	defer func(startTime int64) { c.promMonitor(startTime,"LPush",cmd.Err()) }(prom.NowMillisecond())
+	if err := c.allow("LPush"); err != nil {
+		return redis.NewIntResult(0, err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"LPush",cmd.Err()) }(prom.NowMicrosecond())
 	return c.Client.LPush(key, values...)
 }
 
 func (c RedisClient) RPush(key string, values ...interface{}) (cmd *redis.IntCmd) {
-	defer func(startTime int64) { c.promMonitor(startTime,"RPush",cmd.Err()) }(prom.NowMillisecond())
+	if err := c.allow("RPush"); err != nil {
+		return redis.NewIntResult(0, err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"RPush",cmd.Err()) }(prom.NowMicrosecond())
 	return c.Client.RPush(key, values...)
 }
 
 func (c RedisClient) LLen(key string) (cmd *redis.IntCmd) {
-	defer func(startTime int64) { c.promMonitor(startTime,"LLen",cmd.Err()) }(prom.NowMillisecond())
+	if err := c.allow("LLen"); err != nil {
+		return redis.NewIntResult(0, err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"LLen",cmd.Err()) }(prom.NowMicrosecond())
 	return c.Client.LLen(key)
 }
 
 func (c RedisClient) LPop(key string) (cmd *redis.StringCmd) {
-	defer func(startTime int64) { c.promMonitor(startTime,"LPop",cmd.Err()) }(prom.NowMillisecond())
+	if err := c.allow("LPop"); err != nil {
+		return redis.NewStringResult("", err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"LPop",cmd.Err()) }(prom.NowMicrosecond())
 	return c.Client.LPop(key)
 }
 
 func (c RedisClient) RPop(key string) (cmd *redis.StringCmd) {
-	defer func(startTime int64) { c.promMonitor(startTime,"RPop",cmd.Err()) }(prom.NowMillisecond())
+	if err := c.allow("RPop"); err != nil {
+		return redis.NewStringResult("", err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"RPop",cmd.Err()) }(prom.NowMicrosecond())
 	return c.Client.RPop(key)
 }
 
 func (c RedisClient) LRem(key string, count int64, value interface{}) (cmd *redis.IntCmd) {
-	defer func(startTime int64) { c.promMonitor(startTime,"LRem",cmd.Err()) }(prom.NowMillisecond())
+	if err := c.allow("LRem"); err != nil {
+		return redis.NewIntResult(0, err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"LRem",cmd.Err()) }(prom.NowMicrosecond())
 	return c.Client.LRem(key, count, value)
 }
 
 func (c RedisClient) LIndex(key string, index int64) (cmd *redis.StringCmd) {
-	defer func(startTime int64) { c.promMonitor(startTime,"LIndex",cmd.Err()) }(prom.NowMillisecond())
+	if err := c.allow("LIndex"); err != nil {
+		return redis.NewStringResult("", err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"LIndex",cmd.Err()) }(prom.NowMicrosecond())
 	return c.Client.LIndex(key, index)
 }
 
+func (c RedisClient) LRange(key string, start, stop int64) (cmd *redis.StringSliceCmd) {
+	if err := c.allow("LRange"); err != nil {
+		return redis.NewStringSliceResult(nil, err)
+	}
+	defer func(startTime int64) { c.promMonitor(startTime,"LRange",cmd.Err()) }(prom.NowMicrosecond())
+	return c.Client.LRange(key, start, stop)
+}
+
+// BRPopLPush 超时没有元素时返回 redis.Nil
+func (c RedisClient) BRPopLPush(source, destination string, timeout time.Duration) (cmd *redis.StringCmd) {
+	if err := c.allow("BRPopLPush"); err != nil {
+		return redis.NewStringResult("", err)
+	}
+	defer func(startTime int64) { c.blockingMonitor(startTime,"BRPopLPush",cmd.Err()) }(prom.NowMicrosecond())
+	return c.Client.BRPopLPush(source, destination, timeout)
+}
+
 //------------------------------------End-------------------------------------------
 
+func keysOfNodes(forEach func(fn func(client *redis.Client) error) error, pattern string) *redis.StringSliceCmd {
+	var (
+		mu   sync.Mutex
+		keys []string
+	)
+	err := forEach(func(client *redis.Client) error {
+		nodeKeys, err := client.Keys(pattern).Result()
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		keys = append(keys, nodeKeys...)
+		mu.Unlock()
+		return nil
+	})
+	return redis.NewStringSliceResult(keys, err)
+}
+
 func (c *RedisClient) promMonitor(start int64, method string, err error) {
+	c.report(start, method, err)
+	c.setMetrics(start, method, err)
+}
+
+// blockingMonitor 阻塞命令的耗时主要是等待数据的时间, 熔断器按零耗时统计, 避免空闲时被判定为慢调用
+func (c *RedisClient) blockingMonitor(start int64, method string, err error) {
+	c.report(prom.NowMicrosecond(), method, err)
+	c.setMetrics(start, method, err)
+}
+
+func (c *RedisClient) setMetrics(start int64, method string, err error) {
 	if c.prom {
 		status := "Success"
 		if err != nil {
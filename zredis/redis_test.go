@@ -1,7 +1,10 @@
 package zredis
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"github.com/QuRuijie/zenDB/breaker"
 	"github.com/go-redis/redis"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -180,6 +183,27 @@ var _ = Describe("Test Redis", func() {
 			Expect(unlink).Should(Equal(int64(2)))
 		})
 
+		It("Test Unlink fallback with circuit breaker", func() {
+			// Unlink 失败后回退到 Del, 回退不能再次经过熔断器, 否则半开探测没有结果, 熔断器无法恢复
+			down, err := Connect(&redis.Options{Addr: ADDR + ":1", DialTimeout: 100 * time.Millisecond}, "down", &ConnectOptions{Lazy: true})
+			Expect(err).ShouldNot(HaveOccurred())
+			down.SetCircuitBreaker(breaker.Config{MinRequests: 1, ErrorRate: 0.5, CoolDown: 10 * time.Millisecond, HalfOpenRequests: 1})
+
+			Expect(down.Unlink("key1").Err()).Should(HaveOccurred())
+			Expect(down.CircuitBreaker().State()).Should(Equal(breaker.StateOpen))
+
+			time.Sleep(20 * time.Millisecond)
+			Expect(down.Unlink("key1").Err()).Should(HaveOccurred())
+			Expect(down.CircuitBreaker().State()).Should(Equal(breaker.StateOpen))
+
+			time.Sleep(20 * time.Millisecond)
+			Expect(errors.Is(down.Unlink("key1").Err(), breaker.ErrOpen)).Should(BeFalse())
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			Expect(down.Shutdown(ctx)).ShouldNot(HaveOccurred())
+		})
+
 		It("Test Incr", func() {
 			set, err := client.Set("key", 10, 0).Result()
 			Expect(err).ShouldNot(HaveOccurred())
@@ -857,5 +881,22 @@ var _ = Describe("Test Redis", func() {
 			Expect(err).ShouldNot(HaveOccurred())
 			Expect(lIndex).Should(Equal("r2"))
 		})
+
+		It("Text BRPopLPush", func() {
+			// 空闲时的阻塞等待不算慢调用, 不会触发熔断
+			client.SetCircuitBreaker(breaker.Config{MinRequests: 1, SlowThreshold: 10 * time.Millisecond, SlowRate: 0.5})
+			_, err := client.BRPopLPush("list", "processing", time.Second).Result()
+			Expect(err).Should(Equal(redis.Nil))
+			Expect(client.CircuitBreaker().State()).Should(Equal(breaker.StateClosed))
+
+			lPush, err := client.LPush("list", "l1").Result()
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(lPush).Should(Equal(int64(1)))
+
+			bRPopLPush, err := client.BRPopLPush("list", "processing", time.Second).Result()
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(bRPopLPush).Should(Equal("l1"))
+			Expect(client.LLen("processing").Val()).Should(Equal(int64(1)))
+		})
 	})
 })
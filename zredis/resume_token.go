@@ -0,0 +1,33 @@
+package zredis
+
+import (
+	"context"
+	"github.com/go-redis/redis"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ResumeTokenStore 把 mongo change stream 的 resume token 保存在 redis 中, 实现 zmgo.ResumeTokenStore
+type ResumeTokenStore struct {
+	client *RedisClient
+	prefix string
+}
+
+// NewResumeTokenStore key 为 prefix + 订阅的 TokenKey
+func NewResumeTokenStore(client *RedisClient, prefix string) *ResumeTokenStore {
+	return &ResumeTokenStore{client: client, prefix: prefix}
+}
+
+func (s *ResumeTokenStore) Load(_ context.Context, key string) (bson.Raw, error) {
+	b, err := s.client.Get(s.prefix + key).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return bson.Raw(b), nil
+}
+
+func (s *ResumeTokenStore) Save(_ context.Context, key string, token bson.Raw) error {
+	return s.client.Set(s.prefix+key, []byte(token), 0).Err()
+}
@@ -0,0 +1,39 @@
+package zredis
+
+import (
+	"context"
+	"github.com/go-redis/redis"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var _ = Describe("Test ResumeTokenStore", func() {
+
+	var client *RedisClient
+
+	BeforeEach(func() {
+		client = NewClient(&redis.Options{Addr: ADDR + ":" + PORT, DB: 15}, "test")
+		Expect(client.FlushDB().Err()).ShouldNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(client.Close()).ShouldNot(HaveOccurred())
+	})
+
+	It("Test save and load", func() {
+		store := NewResumeTokenStore(client, "watch:")
+		ctx := context.Background()
+		token, err := store.Load(ctx, "test.user")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(token).Should(BeNil())
+
+		saved, err := bson.Marshal(bson.M{"_data": "8263F1"})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(store.Save(ctx, "test.user", saved)).ShouldNot(HaveOccurred())
+		token, err = store.Load(ctx, "test.user")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(token).Should(Equal(bson.Raw(saved)))
+		Expect(client.Exists("watch:test.user").Val()).Should(Equal(int64(1)))
+	})
+})
@@ -0,0 +1,244 @@
+package zredis
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"github.com/QuRuijie/zenDB/prom"
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	// ErrScriptNotFound RunScriptByName 的脚本没有注册
+	ErrScriptNotFound = errors.New("zredis: script not found")
+	// ErrScriptConflict 同名脚本的源码不同
+	ErrScriptConflict = errors.New("zredis: script name conflict")
+)
+
+// Script 声明一次的 Lua 脚本, 一般定义为包级变量, 可以在多个客户端上执行
+type Script struct {
+	name string
+	src  string
+	hash string
+}
+
+// NewScript 声明脚本, name 用作 prom 指标的 script 标签
+func NewScript(name, src string) *Script {
+	h := sha1.Sum([]byte(src))
+	return &Script{name: name, src: src, hash: hex.EncodeToString(h[:])}
+}
+
+func (s *Script) Name() string {
+	return s.name
+}
+
+// Hash 脚本的 SHA1, 即 EVALSHA 的参数
+func (s *Script) Hash() string {
+	return s.hash
+}
+
+// ScriptCmd 脚本的返回值, 在 redis.Cmd 之外增加数组结果的解析
+type ScriptCmd struct {
+	*redis.Cmd
+}
+
+// StringSlice 解析 Lua 返回的数组, nil(Lua 的 false)元素解析为 ""
+func (cmd *ScriptCmd) StringSlice() ([]string, error) {
+	items, err := cmd.slice()
+	if err != nil {
+		return nil, err
+	}
+	res := make([]string, len(items))
+	for i, item := range items {
+		switch v := item.(type) {
+		case nil:
+		case string:
+			res[i] = v
+		case int64:
+			res[i] = strconv.FormatInt(v, 10)
+		default:
+			return nil, errors.Errorf("zredis: unexpected element type %T in script result", item)
+		}
+	}
+	return res, nil
+}
+
+// Int64Slice 解析 Lua 返回的整数数组, 字符串元素按十进制解析
+func (cmd *ScriptCmd) Int64Slice() ([]int64, error) {
+	items, err := cmd.slice()
+	if err != nil {
+		return nil, err
+	}
+	res := make([]int64, len(items))
+	for i, item := range items {
+		switch v := item.(type) {
+		case int64:
+			res[i] = v
+		case string:
+			if res[i], err = strconv.ParseInt(v, 10, 64); err != nil {
+				return nil, errors.Wrap(err, "zredis: parse script result")
+			}
+		default:
+			return nil, errors.Errorf("zredis: unexpected element type %T in script result", item)
+		}
+	}
+	return res, nil
+}
+
+func (cmd *ScriptCmd) slice() ([]interface{}, error) {
+	val, err := cmd.Result()
+	if err != nil {
+		return nil, err
+	}
+	items, ok := val.([]interface{})
+	if !ok {
+		return nil, errors.Errorf("zredis: script result is %T, not an array", val)
+	}
+	return items, nil
+}
+
+// scriptRegistry 客户端上注册的脚本, loaded 表示已经 SCRIPT LOAD 到 redis
+type scriptRegistry struct {
+	mu      sync.RWMutex
+	scripts map[string]*registeredScript
+}
+
+type registeredScript struct {
+	*Script
+	loaded int32
+}
+
+func newScriptRegistry() *scriptRegistry {
+	return &scriptRegistry{scripts: make(map[string]*registeredScript)}
+}
+
+func (r *scriptRegistry) register(s *Script) (*registeredScript, error) {
+	r.mu.RLock()
+	rs, ok := r.scripts[s.name]
+	r.mu.RUnlock()
+	if !ok {
+		r.mu.Lock()
+		if rs, ok = r.scripts[s.name]; !ok {
+			rs = &registeredScript{Script: s}
+			r.scripts[s.name] = rs
+		}
+		r.mu.Unlock()
+	}
+	if rs.hash != s.hash {
+		return nil, errors.Wrapf(ErrScriptConflict, "script %s", s.name)
+	}
+	return rs, nil
+}
+
+func (r *scriptRegistry) get(name string) (*registeredScript, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	rs, ok := r.scripts[name]
+	return rs, ok
+}
+
+// RegisterScript 在客户端上注册脚本, 之后可以用 RunScriptByName 执行, 同名不同源码的脚本返回 ErrScriptConflict.
+// 注册时不访问 redis, 第一次执行时才 SCRIPT LOAD
+func (c RedisClient) RegisterScript(scripts ...*Script) error {
+	for _, s := range scripts {
+		if _, err := c.scripts.register(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadScripts 启动时预先 SCRIPT LOAD 所有注册的脚本, Cluster 和 Ring 模式下加载到所有节点
+func (c RedisClient) LoadScripts() error {
+	c.scripts.mu.RLock()
+	scripts := make([]*registeredScript, 0, len(c.scripts.scripts))
+	for _, rs := range c.scripts.scripts {
+		scripts = append(scripts, rs)
+	}
+	c.scripts.mu.RUnlock()
+
+	for _, rs := range scripts {
+		if err := c.loadScript(rs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunScript 用 EVALSHA 执行脚本, 没有注册的脚本自动注册.
+// redis 重启或切换后脚本缓存丢失(NOSCRIPT)时改用 EVAL, 下次执行前重新 SCRIPT LOAD
+func (c RedisClient) RunScript(s *Script, keys []string, args ...interface{}) *ScriptCmd {
+	rs, err := c.scripts.register(s)
+	if err != nil {
+		return &ScriptCmd{redis.NewCmdResult(nil, err)}
+	}
+	return c.runScript(rs, keys, args...)
+}
+
+// RunScriptByName 执行 RegisterScript 注册的脚本, 没有注册时返回 ErrScriptNotFound
+func (c RedisClient) RunScriptByName(name string, keys []string, args ...interface{}) *ScriptCmd {
+	rs, ok := c.scripts.get(name)
+	if !ok {
+		return &ScriptCmd{redis.NewCmdResult(nil, errors.Wrapf(ErrScriptNotFound, "script %s", name))}
+	}
+	return c.runScript(rs, keys, args...)
+}
+
+func (c RedisClient) runScript(rs *registeredScript, keys []string, args ...interface{}) (cmd *ScriptCmd) {
+	if err := c.admit(rs.name); err != nil {
+		c.setScriptMetrics(prom.NowMicrosecond(), rs.name, err)
+		return &ScriptCmd{redis.NewCmdResult(nil, err)}
+	}
+	defer func(startTime int64) { c.scriptMonitor(startTime, rs.name, cmd.Err()) }(prom.NowMicrosecond())
+
+	if atomic.LoadInt32(&rs.loaded) == 0 {
+		if err := c.loadScript(rs); err != nil {
+			return &ScriptCmd{redis.NewCmdResult(nil, err)}
+		}
+	}
+	res := c.Client.EvalSha(rs.hash, keys, args...)
+	if err := res.Err(); err != nil && strings.HasPrefix(err.Error(), "NOSCRIPT ") {
+		atomic.StoreInt32(&rs.loaded, 0)
+		res = c.Client.Eval(rs.src, keys, args...)
+	}
+	return &ScriptCmd{res}
+}
+
+func (c RedisClient) loadScript(rs *registeredScript) error {
+	load := func(client *redis.Client) error {
+		return client.ScriptLoad(rs.src).Err()
+	}
+	var err error
+	switch client := c.Client.(type) {
+	case *redis.ClusterClient:
+		err = client.ForEachMaster(load)
+	case *redis.Ring:
+		err = client.ForEachShard(load)
+	default:
+		err = c.Client.ScriptLoad(rs.src).Err()
+	}
+	if err != nil {
+		return errors.Wrapf(err, "load script %s", rs.name)
+	}
+	atomic.StoreInt32(&rs.loaded, 1)
+	return nil
+}
+
+func (c *RedisClient) scriptMonitor(start int64, name string, err error) {
+	c.report(start, name, err)
+	c.setScriptMetrics(start, name, err)
+}
+
+func (c *RedisClient) setScriptMetrics(start int64, name string, err error) {
+	if c.prom {
+		status := "Success"
+		if err != nil {
+			status = "Fail"
+		}
+		prom.SetRedisScriptMetrics(float64(prom.NowMicrosecond()-start), name, status)
+	}
+}
@@ -0,0 +1,71 @@
+package zredis
+
+import (
+	"github.com/go-redis/redis"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+)
+
+var casScript = NewScript("CompareAndSet", `
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	redis.call("set", KEYS[1], ARGV[2])
+	return 1
+end
+return 0`)
+
+var _ = Describe("Test Script Registry", func() {
+
+	It("Test script hash matches go-redis", func() {
+		Expect(casScript.Hash()).Should(Equal(redis.NewScript(casScript.src).Hash()))
+	})
+
+	It("Test register conflict and unknown script", func() {
+		client := &RedisClient{scripts: newScriptRegistry()}
+		Expect(client.RegisterScript(casScript, casScript)).ShouldNot(HaveOccurred())
+		err := client.RegisterScript(NewScript("CompareAndSet", "return 1"))
+		Expect(errors.Is(err, ErrScriptConflict)).Should(BeTrue())
+		err = client.RunScriptByName("Unknown", nil).Err()
+		Expect(errors.Is(err, ErrScriptNotFound)).Should(BeTrue())
+	})
+
+	It("Test typed result decoding", func() {
+		cmd := &ScriptCmd{redis.NewCmdResult([]interface{}{int64(1), "2", nil}, nil)}
+		strs, err := cmd.StringSlice()
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(strs).Should(Equal([]string{"1", "2", ""}))
+		_, err = cmd.Int64Slice()
+		Expect(err).Should(HaveOccurred())
+
+		cmd = &ScriptCmd{redis.NewCmdResult([]interface{}{int64(1), "2"}, nil)}
+		ints, err := cmd.Int64Slice()
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(ints).Should(Equal([]int64{1, 2}))
+	})
+})
+
+var _ = Describe("Test Script", func() {
+
+	var client *RedisClient
+
+	BeforeEach(func() {
+		client = NewClient(&redis.Options{Addr: ADDR + ":" + PORT, DB: 15}, "test")
+		Expect(client.FlushDB().Err()).ShouldNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(client.Close()).ShouldNot(HaveOccurred())
+	})
+
+	It("Test RunScript and NOSCRIPT fallback", func() {
+		Expect(client.Set("cas", "a", 0).Err()).ShouldNot(HaveOccurred())
+		Expect(client.RunScript(casScript, []string{"cas"}, "a", "b").Val()).Should(Equal(int64(1)))
+		Expect(client.RunScript(casScript, []string{"cas"}, "a", "c").Val()).Should(Equal(int64(0)))
+
+		Expect(client.ScriptFlush().Err()).ShouldNot(HaveOccurred())
+		res, err := client.RunScriptByName("CompareAndSet", []string{"cas"}, "b", "c").Int64()
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(res).Should(Equal(int64(1)))
+		Expect(client.Get("cas").Val()).Should(Equal("c"))
+	})
+})